@@ -3,31 +3,142 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 type command struct {
 	String string
 	Tmpl   []interface{}
-	Dir    string
-	Env    map[string]string
+	// Args are appended to the arguments in String as-is, for arguments that
+	// contain spaces
+	Args []string
+	Dir  string
+	Env  map[string]string
+	// Retry re-runs the command on transient failures. nil means run once
+	Retry *retryPolicy
 }
 
+// retryPolicy describes how a command that fails for transient reasons, like
+// a dropped network connection, should be re-attempted
+type retryPolicy struct {
+	// Attempts is the maximum number of runs, including the first one
+	Attempts int
+	// Backoff is the delay before the first retry. The delay doubles with
+	// each subsequent retry
+	Backoff time.Duration
+	// ExitCodes lists exit codes that mark a failure as retryable
+	ExitCodes []int
+	// Stderr lists substrings of stderr output, or of the error returned by
+	// an HTTP request, that mark a failure as retryable
+	Stderr []string
+}
+
+// retryable reports whether a failed run should be tried again. A policy
+// with no matchers treats every non-zero exit as retryable
+func (p *retryPolicy) retryable(err error, stderr string) bool {
+	exitErr, ok := err.(interface{ ExitCode() int })
+	if !ok {
+		// the command didn't run at all (missing binary, bad dir), retrying
+		// won't help
+		return false
+	}
+	if len(p.ExitCodes) == 0 && len(p.Stderr) == 0 {
+		return true
+	}
+	for _, code := range p.ExitCodes {
+		if exitErr.ExitCode() == code {
+			return true
+		}
+	}
+	for _, str := range p.Stderr {
+		if strings.Contains(stderr, str) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryableRequest reports whether a failed HTTP request should be tried
+// again. only errors matching Stderr are retried
+func (p *retryPolicy) retryableRequest(err error) bool {
+	for _, str := range p.Stderr {
+		if strings.Contains(err.Error(), str) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	// gitRetryPolicy covers git network operations like pull & fetch
+	gitRetryPolicy = &retryPolicy{
+		Attempts: 4,
+		Backoff:  2 * time.Second,
+		Stderr: []string{
+			"Could not resolve host",
+			"Connection timed out",
+			"Connection reset",
+			"Operation timed out",
+			"The remote end hung up unexpectedly",
+			"early EOF",
+			"unable to access",
+		},
+	}
+	// yarnRetryPolicy covers yarn dependency installs
+	yarnRetryPolicy = &retryPolicy{
+		Attempts: 3,
+		Backoff:  5 * time.Second,
+		Stderr: []string{
+			"ETIMEDOUT",
+			"ESOCKETTIMEDOUT",
+			"ECONNRESET",
+			"ECONNREFUSED",
+			"ENOTFOUND",
+			"EAI_AGAIN",
+			"There appears to be trouble with your network connection",
+		},
+	}
+	// notaryRetryPolicy covers uploads to apple's notary service
+	notaryRetryPolicy = &retryPolicy{
+		Attempts: 3,
+		Backoff:  10 * time.Second,
+		Stderr: []string{
+			"The network connection was lost",
+			"The request timed out",
+			"HTTP status code: 5",
+		},
+	}
+	// ipfsRetryPolicy covers requests to an ipfs node's API
+	ipfsRetryPolicy = &retryPolicy{
+		Attempts: 3,
+		Backoff:  time.Second,
+		Stderr: []string{
+			"connection refused",
+			"connection reset",
+			"context deadline exceeded",
+			"i/o timeout",
+			"502 Bad Gateway",
+			"503 Service Unavailable",
+			"504 Gateway Timeout",
+		},
+	}
+)
+
 // Run executes a command
 func (c command) Run() error {
-	return c.prepare(false).Run()
+	return c.run(false, os.Stdout)
 }
 
 // RunStdout executes a command, returning whatever is printed to stdout
 // as a string
 func (c command) RunStdout() (res string, err error) {
 	buf := &bytes.Buffer{}
-	cmd := c.prepare(false)
-	cmd.Stdout = buf
-	if err = cmd.Run(); err != nil {
+	if err = c.run(false, buf); err != nil {
 		return
 	}
 	res = buf.String()
@@ -36,18 +147,47 @@ func (c command) RunStdout() (res string, err error) {
 
 func (c command) SecretRunStdout() (res string, err error) {
 	buf := &bytes.Buffer{}
-	cmd := c.prepare(true)
-	cmd.Stdout = buf
-	if err = cmd.Run(); err != nil {
+	if err = c.run(true, buf); err != nil {
 		return
 	}
 	res = buf.String()
 	return
 }
 
+// run executes the command, writing stdout to w and applying the retry
+// policy if one is set. output from failed attempts is discarded when w is a
+// buffer
+func (c command) run(quiet bool, w io.Writer) (err error) {
+	if c.Retry == nil || c.Retry.Attempts < 2 {
+		cmd := c.prepare(quiet)
+		cmd.Stdout = w
+		return executor.Run(cmd)
+	}
+
+	backoff := c.Retry.Backoff
+	for attempt := 1; ; attempt++ {
+		if buf, ok := w.(*bytes.Buffer); ok {
+			buf.Reset()
+		}
+		stderr := &bytes.Buffer{}
+		cmd := c.prepare(quiet)
+		cmd.Stdout = w
+		cmd.Stderr = io.MultiWriter(os.Stderr, stderr)
+
+		err = executor.Run(cmd)
+		if err == nil || attempt >= c.Retry.Attempts || !c.Retry.retryable(err, stderr.String()) {
+			return err
+		}
+
+		log.Warnf("%s failed (attempt %d of %d): %s. retrying in %s", strings.Fields(c.String)[0], attempt, c.Retry.Attempts, err, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
 func (c command) prepare(quiet bool) *exec.Cmd {
 	str := fmt.Sprintf(c.String, c.Tmpl...)
-	args := strings.Split(str, " ")
+	args := append(strings.Split(str, " "), c.Args...)
 	name := args[0]
 
 	if !quiet {
@@ -85,6 +225,21 @@ func envs(vars map[string]string) (envs []string) {
 	return
 }
 
+// environ returns the current process environment as a map, with overrides
+// applied on top
+func environ(overrides map[string]string) map[string]string {
+	env := map[string]string{}
+	for _, kv := range os.Environ() {
+		if i := strings.Index(kv, "="); i > 0 {
+			env[kv[:i]] = kv[i+1:]
+		}
+	}
+	for key, val := range overrides {
+		env[key] = val
+	}
+	return env
+}
+
 func flags(vars map[string]string) (flags []string) {
 	for key, val := range vars {
 		if val == "true" {
@@ -121,7 +276,12 @@ func npmDoPath(pwd string) (path string, err error) {
 	npmBinPath = strings.TrimSpace(npmBinPath)
 
 	if err != nil {
-		return
+		// npm 9 removed 'npm bin', local binaries are always here
+		abs, absErr := filepath.Abs(pwd)
+		if absErr != nil {
+			return "", err
+		}
+		npmBinPath = filepath.Join(abs, "node_modules", ".bin")
 	}
 
 	path = os.Getenv("PATH")
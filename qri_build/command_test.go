@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeFlakyBin writes an executable named name to a temp directory on $PATH.
+// it fails with stderr on its first failures runs, then prints "ok"
+func fakeFlakyBin(t *testing.T, name string, failures int, stderr string) (counter string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake executables are shell scripts")
+	}
+	dir := t.TempDir()
+	counter = filepath.Join(dir, "runs")
+	script := `#!/bin/sh
+echo x >> "` + counter + `"
+runs=$(wc -l < "` + counter + `")
+if [ "$runs" -le ` + strconv.Itoa(failures) + ` ]; then
+	echo "` + stderr + `" >&2
+	exit 1
+fi
+echo ok
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	t.Cleanup(func() { os.Setenv("PATH", path) })
+	return counter
+}
+
+func runCount(t *testing.T, counter string) int {
+	t.Helper()
+	data, err := ioutil.ReadFile(counter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Count(string(data), "\n")
+}
+
+func TestCommandRetriesFlakyFailure(t *testing.T) {
+	counter := fakeFlakyBin(t, "flaky", 2, "fatal: unable to access 'https://github.com/qri-io/qri/': Connection reset")
+	policy := &retryPolicy{Attempts: 3, Backoff: time.Millisecond, Stderr: gitRetryPolicy.Stderr}
+
+	out, err := command{String: "flaky pull", Retry: policy}.SecretRunStdout()
+	if err != nil {
+		t.Fatalf("expected retries to succeed, got: %s", err)
+	}
+	if strings.TrimSpace(out) != "ok" {
+		t.Errorf("expected output of the successful attempt only, got %q", out)
+	}
+	if runs := runCount(t, counter); runs != 3 {
+		t.Errorf("expected 3 runs, got %d", runs)
+	}
+}
+
+func TestCommandRetryGivesUp(t *testing.T) {
+	counter := fakeFlakyBin(t, "flaky", 5, "ETIMEDOUT")
+	policy := &retryPolicy{Attempts: 2, Backoff: time.Millisecond, Stderr: yarnRetryPolicy.Stderr}
+
+	if err := (command{String: "flaky", Retry: policy}).Run(); err == nil {
+		t.Fatal("expected an error after exhausting attempts")
+	}
+	if runs := runCount(t, counter); runs != 2 {
+		t.Errorf("expected 2 runs, got %d", runs)
+	}
+}
+
+func TestCommandNoRetryOnPermanentFailure(t *testing.T) {
+	counter := fakeFlakyBin(t, "flaky", 1, "error: pathspec 'nope' did not match")
+	policy := &retryPolicy{Attempts: 3, Backoff: time.Millisecond, Stderr: gitRetryPolicy.Stderr}
+
+	if err := (command{String: "flaky", Retry: policy}).Run(); err == nil {
+		t.Fatal("expected an error")
+	}
+	if runs := runCount(t, counter); runs != 1 {
+		t.Errorf("expected a non-transient failure to run once, got %d runs", runs)
+	}
+}
+
+func TestCommandNoRetryWhenMissing(t *testing.T) {
+	policy := &retryPolicy{Attempts: 3, Backoff: time.Millisecond}
+	if err := (command{String: "qri_build_no_such_binary", Retry: policy}).Run(); err == nil {
+		t.Fatal("expected an error for a missing binary")
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// Config holds qri_build settings that are shared across commands & would be
+// unwieldy as flags. It's read from a JSON file passed with --config
+type Config struct {
+	Toolchain ToolchainConfig `json:"toolchain"`
+	// Targets maps target patterns to build profiles. see Config.Profile for
+	// how targets are matched
+	Targets map[string]BuildProfile `json:"targets"`
+	// Packages holds metadata for linux packages
+	Packages PackagesConfig `json:"packages"`
+	// Repository configures generated apt & yum repositories
+	Repository RepositoryConfig `json:"repository"`
+	// Signing configures release artifact signatures
+	Signing SigningConfig `json:"signing"`
+	// Codesign configures platform codesigning & notarization
+	Codesign CodesignConfig `json:"codesign"`
+	// Licenses is the policy dependency licenses are checked against
+	Licenses LicensePolicy `json:"licenses"`
+	// Docs configures generated shell completions & man pages
+	Docs DocsConfig `json:"docs"`
+	// IPFS configures publishing releases to IPFS
+	IPFS IPFSConfig `json:"ipfs"`
+	// Webapp configures webapp builds
+	Webapp WebappConfig `json:"webapp"`
+	// Desktop configures desktop app releases
+	Desktop DesktopConfig `json:"desktop"`
+	// Install configures where releases are installed from
+	Install InstallConfig `json:"install"`
+}
+
+// ToolchainConfig controls which go toolchain builds use
+type ToolchainConfig struct {
+	// Managed builds with a pinned go toolchain matching the version the qri
+	// repo's go.mod requires, instead of whatever go is on $PATH
+	Managed bool `json:"managed"`
+	// CacheDir is where downloaded toolchains are kept. defaults to
+	// qri_build/toolchains in the user cache directory
+	CacheDir string `json:"cacheDir"`
+	// MirrorURL is the base URL go release archives are fetched from
+	MirrorURL string `json:"mirrorURL"`
+}
+
+// DefaultGoMirrorURL is the base URL of official go release archives
+const DefaultGoMirrorURL = "https://dl.google.com/go"
+
+// cfg is the active configuration
+var cfg = DefaultConfig()
+
+// DefaultConfig returns a configuration with default values set
+func DefaultConfig() *Config {
+	return &Config{
+		Toolchain: ToolchainConfig{
+			MirrorURL: DefaultGoMirrorURL,
+		},
+		Packages: PackagesConfig{
+			Maintainer: "Qri, Inc.",
+		},
+		Repository: RepositoryConfig{
+			Origin:    "qri",
+			Label:     "qri",
+			Suite:     "stable",
+			Component: "main",
+		},
+		Licenses: LicensePolicy{
+			Allow:   DefaultAllowedLicenses,
+			Unknown: "warn",
+		},
+		Docs: DocsConfig{
+			CompletionArgs: []string{"completion", "{shell}"},
+		},
+		IPFS: IPFSConfig{
+			API: DefaultIPFSAPI,
+		},
+		Webapp: WebappConfig{
+			Scripts: map[string]string{
+				"minified":   "build",
+				"unminified": "build:dev",
+			},
+			BuildDir: "dist",
+			PathVar:  "github.com/qri-io/qri/api.DefaultWebappPath",
+			EmbedDir: "api",
+		},
+		Install: InstallConfig{
+			Latest:  DefaultInstallLatest,
+			Release: DefaultInstallRelease,
+		},
+	}
+}
+
+// LoadConfig reads a JSON configuration file. Fields missing from the file
+// keep their default values
+func LoadConfig(path string) (*Config, error) {
+	c := DefaultConfig()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("parsing config %s: %s", path, err)
+	}
+	return c, nil
+}
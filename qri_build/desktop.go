@@ -10,7 +10,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/blang/semver"
 	"github.com/spf13/cobra"
 )
 
@@ -21,7 +20,8 @@ var DesktopCmd = &cobra.Command{
 	Long: `
 build the qri desktop app, by first buliding the qri command-line binary and copying it
 into the desktop's folder. This command is dependent upon having a correct $GOPATH,
-having $GO111MODULE set to 'on', and having both 'go' and 'yarn' installed.
+go modules enabled, and having both 'go' and 'yarn' installed. Run 'qri_build doctor'
+to check your environment.
 
 The directories for the 'qri' and 'desktop' source code need to be specified as command-line
 arguments. For convenience, both will have their code pulled from the git origin, which
@@ -29,6 +29,15 @@ requires them to have the 'master' branch checked out.
 
 The final installed that is built will have it's path displayed once this process completes
 without any errors.
+
+With --publish the installer & its update files are uploaded to a draft github release
+once they're signed, authenticated by the $` + githubTokenEnvVar + ` environment variable.
+electron-builder never publishes on its own, so unsigned files are never uploaded.
+
+electron-builder's auto-update metadata (latest.yml, latest-mac.yml) is copied to
+output/ with every file it lists & their blockmaps, except blockmaps of installers
+signed after electron-builder built them. Listed URLs are rewritten to
+"desktop.updateURL" from the --config file.
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		qriPath, err := cmd.Flags().GetString("qri")
@@ -49,7 +58,13 @@ without any errors.
 			return
 		}
 
-		if err := DesktopBuildPackage(desktopPath, qriPath, !noUpdate, nil, nil); err != nil {
+		publish, err := cmd.Flags().GetBool("publish")
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		if err := DesktopBuildPackage(desktopPath, qriPath, !noUpdate, publish, nil, nil); err != nil {
 			log.Errorf("%s", err)
 		}
 	},
@@ -59,16 +74,18 @@ func init() {
 	DesktopCmd.Flags().String("qri", "", "path to qri repository")
 	DesktopCmd.Flags().String("desktop", "", "path to qri desktop repo")
 	DesktopCmd.Flags().Bool("no-update-source", false, "don't switch & pull master branches")
+	DesktopCmd.Flags().Bool("publish", false, "publish the installer to a draft github release. requires $"+githubTokenEnvVar)
 }
 
-// MinRequiredGoVersion is the required version of go needed to build qri
-var MinRequiredGoVersion = semver.MustParse("1.12.0")
-
-// DesktopBuildPackage builds the desktop app with the necessary qri binary
-func DesktopBuildPackage(desktopPath, qriPath string, pullMaster bool, platforms, arches []string) (err error) {
+// DesktopBuildPackage builds the desktop app with the necessary qri binary.
+// publish uploads the installer to a draft github release
+func DesktopBuildPackage(desktopPath, qriPath string, pullMaster, publish bool, platforms, arches []string) (err error) {
 	if qriPath == "" || desktopPath == "" {
 		return fmt.Errorf("Flags --qri and --desktop are both required")
 	}
+	if publish && os.Getenv(githubTokenEnvVar) == "" {
+		return fmt.Errorf("publishing requires a github token in $%s", githubTokenEnvVar)
+	}
 
 	// Ensure source directories exist
 	if _, err := os.Stat(qriPath); os.IsNotExist(err) {
@@ -78,13 +95,6 @@ func DesktopBuildPackage(desktopPath, qriPath string, pullMaster bool, platforms
 		return fmt.Errorf("Directory \"%s\" does not exist", desktopPath)
 	}
 
-	// Ensure valid go version, go modules
-	log.Infof("ensuring valid go version and go modules support...")
-	err = ensureGoEnvVars()
-	if err != nil {
-		return err
-	}
-
 	if pullMaster {
 		// Update source code for qri binary
 		log.Infof("updating source code for qri...")
@@ -128,9 +138,23 @@ func DesktopBuildPackage(desktopPath, qriPath string, pullMaster bool, platforms
 		return err
 	}
 
+	signer, err := NewSigner(cfg.Codesign)
+	if err != nil {
+		return err
+	}
+	if runtime.GOOS == "darwin" {
+		// Sign the backend binary so it passes notarization inside the app bundle
+		log.Infof("signing qri binary...")
+		if _, err = signer.SignBinary(backendBinary); err != nil {
+			return err
+		}
+	}
+
 	// Build desktop app installer
 	log.Infof("building desktop app installer...")
-	err = buildDesktopApp(desktopPath)
+	if err = buildDesktopApp(desktopPath); err != nil {
+		return err
+	}
 
 	// Find built installer
 	builtDesktopInstaller, err := discoverDesktopInstaller(desktopPath)
@@ -154,10 +178,96 @@ func DesktopBuildPackage(desktopPath, qriPath string, pullMaster bool, platforms
 		return err
 	}
 
+	// Sign & notarize the installer. windows installers are left to
+	// electron-builder
+	var (
+		sig    *Signature
+		signed []string
+	)
+	if strings.HasSuffix(releaseTarget, ".dmg") {
+		log.Infof("signing desktop app installer...")
+		if sig, err = signer.SignDistributable(releaseTarget); err != nil {
+			return err
+		}
+		signed = append(signed, basename)
+	}
+
+	// Collect auto-update metadata, now that installers are in their final state
+	log.Infof("collecting desktop app update files...")
+	updateFiles, err := CollectDesktopUpdateFiles(filepath.Join(desktopPath, "release"), finalPath, cfg.Desktop.UpdateURL, signed)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("writing desktop app bill of materials...")
+	sbomPath := strings.TrimSuffix(releaseTarget, filepath.Ext(releaseTarget)) + ".cdx.json"
+	if err = writeDesktopSBOM(desktopPath, qriPath, builtPath, sbomPath); err != nil {
+		return err
+	}
+
+	manifest, err := LoadManifest(finalPath)
+	if err != nil {
+		return err
+	}
+	if _, err = manifest.Add(sbomPath, ArtifactSBOM, nil, nil); err != nil {
+		return err
+	}
+	for _, path := range updateFiles {
+		if _, err = manifest.Add(path, ArtifactUpdate, nil, nil); err != nil {
+			return err
+		}
+	}
+	installer, err := manifest.Add(releaseTarget, ArtifactInstaller, nil, sig)
+	if err != nil {
+		return err
+	}
+	installer.SBOM = filepath.Base(sbomPath)
+	if err = manifest.Save(); err != nil {
+		return err
+	}
+
+	if publish {
+		log.Infof("publishing desktop app installer...")
+		if err = publishDesktopApp(desktopPath, append([]string{releaseTarget}, updateFiles...)); err != nil {
+			return err
+		}
+	}
+
 	fmt.Printf("Release installer at: %s\n", releaseTarget)
 	return nil
 }
 
+// writeDesktopSBOM writes a bill of materials for the desktop installer,
+// combining the go modules of the bundled qri binary with the npm packages
+// pinned in the desktop's yarn.lock
+func writeDesktopSBOM(desktopPath, qriPath, qriBinPath, sbomPath string) error {
+	goBin, err := goBinary(qriPath)
+	if err != nil {
+		return err
+	}
+	qriVersion, _ := QriVersion(qriPath)
+	qriSBOM, err := GoBinarySBOM(goBin, qriBinPath, qriVersion)
+	if err != nil {
+		return err
+	}
+
+	pkg, err := readPackageJSON(desktopPath)
+	if err != nil {
+		return err
+	}
+
+	npmDeps, err := YarnLockComponents(filepath.Join(desktopPath, "yarn.lock"))
+	if err != nil {
+		return fmt.Errorf("reading desktop yarn.lock: %s", err)
+	}
+
+	sbom := NewSBOM(pkg.Name, pkg.Version)
+	sbom.AddComponents(qriSBOM.Metadata.Component)
+	sbom.AddComponents(qriSBOM.Components...)
+	sbom.AddComponents(npmDeps...)
+	return sbom.WriteFile(sbomPath)
+}
+
 // updateSource ensures that the "master" branch is checked out, then pulls from the origin
 func updateSource(path string) error {
 	branchName, err := getCurrentGitBranch(path)
@@ -179,6 +289,13 @@ func updateSource(path string) error {
 
 // buildQriBinary will build the qri binary, returning the path of the built binary
 func buildQriBinary(projectPath string) (string, error) {
+	// Ensure valid go version, go modules
+	log.Infof("ensuring valid go version and go modules support...")
+	goBin, err := goBinary(projectPath)
+	if err != nil {
+		return "", err
+	}
+
 	buildPath := filepath.Join(projectPath, "build")
 	targetBinPath := filepath.Join(buildPath, "qri")
 
@@ -189,11 +306,13 @@ func buildQriBinary(projectPath string) (string, error) {
 	}
 
 	cmd := command{
-		String: "go build -o build/qri",
+		String: "%s build -o build/qri",
+		Tmpl:   []interface{}{goBin},
 		Dir:    projectPath,
+		Env:    goEnv(environ(nil)),
 	}
 
-	err := cmd.Run()
+	err = cmd.Run()
 	if err != nil {
 		return "", err
 	}
@@ -201,11 +320,16 @@ func buildQriBinary(projectPath string) (string, error) {
 	return targetBinPath, nil
 }
 
-// buildDesktopApp will build the distributable electron installer for desktop
+// githubTokenEnvVar is the token electron-builder publishes releases with
+const githubTokenEnvVar = "GH_TOKEN"
+
+// buildDesktopApp will build the distributable electron installer for desktop.
+// publishing is left to publishDesktopApp, after the installer is signed
 func buildDesktopApp(path string) error {
 	cmd := command{
 		String: "yarn",
 		Dir:    path,
+		Retry:  yarnRetryPolicy,
 	}
 
 	err := cmd.Run()
@@ -214,7 +338,7 @@ func buildDesktopApp(path string) error {
 	}
 
 	cmd = command{
-		String: "yarn dist",
+		String: "yarn dist --publish never",
 		Dir:    path,
 	}
 
@@ -226,6 +350,24 @@ func buildDesktopApp(path string) error {
 	return nil
 }
 
+// publishDesktopApp uploads files to the draft github release electron-builder
+// is configured to publish to in the desktop repo
+func publishDesktopApp(path string, files []string) error {
+	cmd := command{
+		String: "yarn electron-builder publish",
+		Dir:    path,
+		Retry:  yarnRetryPolicy,
+	}
+	for _, f := range files {
+		abs, err := filepath.Abs(f)
+		if err != nil {
+			return err
+		}
+		cmd.Args = append(cmd.Args, "--files", abs)
+	}
+	return cmd.Run()
+}
+
 func discoverDesktopInstaller(path string) (string, error) {
 	releaseDirPath := filepath.Join(path, "release")
 	finfos, err := ioutil.ReadDir(releaseDirPath)
@@ -249,42 +391,6 @@ func discoverDesktopInstaller(path string) (string, error) {
 	return latestFilename, nil
 }
 
-func ensureGoEnvVars() error {
-	cmd := command{
-		String: "go version",
-	}
-
-	output, err := cmd.RunStdout()
-	if err != nil {
-		return err
-	}
-
-	// strip off output prefix from go version
-	output = strings.TrimPrefix(output, "go version go")
-	// grab version numbers
-	output = strings.Split(output, " ")[0]
-	// rediculous need for patch suffix. why is software terrible
-	if strings.Count(output, ".") == 1 {
-		output += ".0"
-	}
-
-	goVersion, err := semver.Make(output)
-	if err != nil {
-		return fmt.Errorf("invalid output from 'go version': %s\noutput:%s", err, output)
-	}
-
-	if !MinRequiredGoVersion.LTE(goVersion) {
-		return fmt.Errorf("go version %s is below minimum required version: %s", goVersion.String(), MinRequiredGoVersion.String())
-	}
-
-	value := os.Getenv("GO111MODULE")
-	if value != "on" {
-		return fmt.Errorf("Error: must set envvar `GO111MODULE` to `on`")
-	}
-
-	return nil
-}
-
 // getCurrentGitBranch returns the currently checked out git branch
 func getCurrentGitBranch(path string) (string, error) {
 	cmd := command{
@@ -312,6 +418,7 @@ func doGitPull(path string) error {
 	cmd := command{
 		String: "git pull",
 		Dir:    path,
+		Retry:  gitRetryPolicy,
 	}
 	return cmd.Run()
 }
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DesktopConfig configures desktop app releases
+type DesktopConfig struct {
+	// UpdateURL is the base URL desktop installers & update files are
+	// downloaded from. auto-update metadata is rewritten to point here. when
+	// empty, URLs are left relative to the update metadata
+	UpdateURL string `json:"updateURL"`
+}
+
+// updateFile is a file listed in electron-builder update metadata
+type updateFile struct {
+	Name   string
+	SHA512 string
+	Size   int64
+}
+
+// CollectDesktopUpdateFiles copies electron-builder's auto-update metadata
+// (latest*.yml) from releaseDir to outDir, along with every file it lists &
+// their blockmaps, replacing any left in outDir by earlier builds. listed URLs
+// are rewritten to updateURL, and checksums are recalculated from outDir.
+// signed names files in outDir that were signed after electron-builder built
+// them. they're kept as they are, and their blockmaps are dropped: a blockmap
+// describes the unsigned file, and electron-updater falls back to a full
+// download without one. returns the paths written, excluding signed files
+func CollectDesktopUpdateFiles(releaseDir, outDir, updateURL string, signed []string) ([]string, error) {
+	ymls, err := filepath.Glob(filepath.Join(releaseDir, "latest*.yml"))
+	if err != nil {
+		return nil, err
+	}
+	if len(ymls) == 0 {
+		log.Warnf("no auto-update metadata in %s", releaseDir)
+		return nil, nil
+	}
+	sort.Strings(ymls)
+
+	isSigned := map[string]bool{}
+	for _, name := range signed {
+		isSigned[name] = true
+	}
+
+	var written []string
+	for _, yml := range ymls {
+		data, err := ioutil.ReadFile(yml)
+		if err != nil {
+			return nil, err
+		}
+
+		names := updateFileNames(string(data))
+		files := map[string]updateFile{}
+		for _, name := range names {
+			dest := filepath.Join(outDir, name)
+			blockmap := filepath.Join(outDir, name+".blockmap")
+			if isSigned[name] {
+				if err := os.Remove(blockmap); err != nil && !os.IsNotExist(err) {
+					return nil, err
+				}
+			} else {
+				if err := CopyFile(filepath.Join(releaseDir, name), dest); err != nil {
+					return nil, fmt.Errorf("%s lists %s: %s", filepath.Base(yml), name, err)
+				}
+				written = append(written, dest)
+				if src := filepath.Join(releaseDir, name+".blockmap"); fileExists(src) {
+					if err := CopyFile(src, blockmap); err != nil {
+						return nil, err
+					}
+					written = append(written, blockmap)
+				}
+			}
+			if files[name], err = sumUpdateFile(dest); err != nil {
+				return nil, err
+			}
+		}
+
+		dest := filepath.Join(outDir, filepath.Base(yml))
+		if err := ioutil.WriteFile(dest, []byte(rewriteUpdateYAML(string(data), files, updateURL)), 0644); err != nil {
+			return nil, err
+		}
+		written = append(written, dest)
+		log.Infof("collected %s, listing %s", filepath.Base(yml), strings.Join(names, ", "))
+	}
+	return written, nil
+}
+
+// updateFileNames lists the file names referenced by "url" & "path" keys in
+// electron-builder update metadata
+func updateFileNames(yml string) (names []string) {
+	seen := map[string]bool{}
+	sc := bufio.NewScanner(strings.NewReader(yml))
+	for sc.Scan() {
+		key, val, _, ok := updateYAMLField(sc.Text())
+		if !ok || (key != "url" && key != "path") {
+			continue
+		}
+		name := updateFileName(val)
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// updateFileName strips any URL prefix from a listed file
+func updateFileName(val string) string {
+	if i := strings.LastIndex(val, "/"); i >= 0 {
+		return val[i+1:]
+	}
+	return val
+}
+
+// rewriteUpdateYAML points "url" & "path" values at updateURL & replaces
+// "sha512" & "size" values with those in files. electron-builder writes a
+// flat layout: a "files" list of url/sha512/size entries, then a top level
+// "path" & "sha512" for the main file, so a line belongs to the nearest
+// preceding url (in the list) or path (at the top level)
+func rewriteUpdateYAML(yml string, files map[string]updateFile, updateURL string) string {
+	var (
+		out          []string
+		listFile     string
+		topLevelFile string
+	)
+	lines := strings.Split(yml, "\n")
+	for _, line := range lines {
+		key, val, indent, ok := updateYAMLField(line)
+		if !ok {
+			out = append(out, line)
+			continue
+		}
+		prefix := line[:strings.Index(line, key+":")]
+		current := &topLevelFile
+		if indent > 0 {
+			current = &listFile
+		}
+
+		switch key {
+		case "url", "path":
+			*current = updateFileName(val)
+			if updateURL != "" {
+				line = fmt.Sprintf("%s%s: %s/%s", prefix, key, strings.TrimSuffix(updateURL, "/"), *current)
+			}
+		case "sha512":
+			if f, ok := files[*current]; ok {
+				line = fmt.Sprintf("%s%s: %s", prefix, key, f.SHA512)
+			}
+		case "size":
+			if f, ok := files[*current]; ok {
+				line = fmt.Sprintf("%s%s: %d", prefix, key, f.Size)
+			}
+		}
+		out = append(out, line)
+	}
+	return strings.Join(out, "\n")
+}
+
+// updateYAMLField reads a "key: value" line, allowing for a "- " list item
+// marker. values are unquoted
+func updateYAMLField(line string) (key, val string, indent int, ok bool) {
+	trimmed := strings.TrimLeft(line, " ")
+	indent = len(line) - len(trimmed)
+	if strings.HasPrefix(trimmed, "- ") {
+		trimmed = trimmed[2:]
+		indent += 2
+	}
+	i := strings.Index(trimmed, ": ")
+	if i < 0 {
+		return "", "", 0, false
+	}
+	key = trimmed[:i]
+	val = strings.Trim(strings.TrimSpace(trimmed[i+2:]), `'"`)
+	return key, val, indent, val != ""
+}
+
+// sumUpdateFile calculates the base64 sha512 electron-updater checks
+// downloads against
+func sumUpdateFile(path string) (updateFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return updateFile{}, err
+	}
+	defer f.Close()
+	h := sha512.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return updateFile{}, err
+	}
+	return updateFile{Name: filepath.Base(path), SHA512: base64.StdEncoding.EncodeToString(h.Sum(nil)), Size: size}, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
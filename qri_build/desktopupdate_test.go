@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testLatestMacYML = `version: 0.5.0
+files:
+  - url: Qri-Desktop-0.5.0-mac.zip
+    sha512: unsignedzip
+    size: 3
+    blockMapSize: 8
+  - url: Qri-Desktop-0.5.0.dmg
+    sha512: unsigneddmg
+    size: 12
+path: Qri-Desktop-0.5.0-mac.zip
+sha512: unsignedzip
+releaseDate: '2020-01-01T00:00:00.000Z'
+`
+
+func TestCollectDesktopUpdateFiles(t *testing.T) {
+	release, out := t.TempDir(), t.TempDir()
+	writeFiles(t, release, map[string]string{
+		"latest-mac.yml":                     testLatestMacYML,
+		"Qri-Desktop-0.5.0-mac.zip":          "zip",
+		"Qri-Desktop-0.5.0-mac.zip.blockmap": "zipmap",
+		"Qri-Desktop-0.5.0.dmg":              "unsigned dmg",
+		"Qri-Desktop-0.5.0.dmg.blockmap":     "dmgmap",
+	})
+	writeFiles(t, out, map[string]string{
+		// left behind by an earlier build
+		"Qri-Desktop-0.5.0-mac.zip":      "stale zip",
+		"Qri-Desktop-0.5.0.dmg.blockmap": "stale dmgmap",
+		// just signed & stapled
+		"Qri-Desktop-0.5.0.dmg": "signed dmg",
+	})
+
+	written, err := CollectDesktopUpdateFiles(release, out, "https://dl.qri.io/desktop/", []string{"Qri-Desktop-0.5.0.dmg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, path := range written {
+		names = append(names, filepath.Base(path))
+	}
+	expect := "Qri-Desktop-0.5.0-mac.zip Qri-Desktop-0.5.0-mac.zip.blockmap latest-mac.yml"
+	if got := strings.Join(names, " "); got != expect {
+		t.Errorf("expected to write %s, wrote %s", expect, got)
+	}
+
+	contents := map[string]string{
+		"Qri-Desktop-0.5.0-mac.zip":          "zip",
+		"Qri-Desktop-0.5.0-mac.zip.blockmap": "zipmap",
+		"Qri-Desktop-0.5.0.dmg":              "signed dmg",
+	}
+	for name, data := range contents {
+		got, err := ioutil.ReadFile(filepath.Join(out, name))
+		if err != nil || string(got) != data {
+			t.Errorf("%s: expected %q, got %q (%v)", name, data, got, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(out, "Qri-Desktop-0.5.0.dmg.blockmap")); !os.IsNotExist(err) {
+		t.Errorf("expected the signed dmg's blockmap to be removed, got %v", err)
+	}
+
+	yml, err := ioutil.ReadFile(filepath.Join(out, "latest-mac.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zip, err := sumUpdateFile(filepath.Join(out, "Qri-Desktop-0.5.0-mac.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dmg, err := sumUpdateFile(filepath.Join(out, "Qri-Desktop-0.5.0.dmg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"  - url: https://dl.qri.io/desktop/Qri-Desktop-0.5.0-mac.zip",
+		"    sha512: " + zip.SHA512,
+		"  - url: https://dl.qri.io/desktop/Qri-Desktop-0.5.0.dmg",
+		"    sha512: " + dmg.SHA512,
+		"    size: 10",
+		"path: https://dl.qri.io/desktop/Qri-Desktop-0.5.0-mac.zip",
+		"sha512: " + zip.SHA512,
+	} {
+		if !strings.Contains(string(yml), line+"\n") {
+			t.Errorf("expected latest-mac.yml to contain %q, got:\n%s", line, yml)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DocsConfig configures how shell completions & man pages are generated
+type DocsConfig struct {
+	// CompletionArgs are the qri arguments that print a completion script.
+	// "{shell}" is replaced with bash, zsh or fish. defaults to cobra's
+	// "completion {shell}"
+	CompletionArgs []string `json:"completionArgs"`
+	// ManArgs are qri arguments that write man pages to a directory, "{dir}".
+	// when empty, man pages are generated from each command's --help output
+	ManArgs []string `json:"manArgs"`
+}
+
+// completionShells are the shells completions are generated for, in the
+// order they're generated
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// CLIDocs are shell completions & man pages generated by running a qri
+// binary
+type CLIDocs struct {
+	// Completions maps shell names to completion scripts
+	Completions map[string][]byte
+	// ManPages maps man page file names, eg. "qri-save.1", to roff source
+	ManPages map[string][]byte
+}
+
+// GenerateCLIDocs builds qri for the host platform & runs it to produce
+// completions & man pages. qri runs with a throwaway home directory so it
+// can't touch a real qri repo
+func GenerateCLIDocs(qriRepoPath, version string, c DocsConfig) (*CLIDocs, error) {
+	binPath, err := buildQriBinary(qriRepoPath)
+	if err != nil {
+		return nil, fmt.Errorf("building host qri binary: %s", err)
+	}
+
+	home, err := ioutil.TempDir("", "qri_build_docs")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(home)
+	env := environ(map[string]string{"HOME": home, "QRI_PATH": filepath.Join(home, "qri")})
+
+	docs := &CLIDocs{Completions: map[string][]byte{}, ManPages: map[string][]byte{}}
+	for _, shell := range completionShells {
+		args := replaceArgs(c.CompletionArgs, "{shell}", shell)
+		out, err := command{String: binPath, Args: args, Env: env}.SecretRunStdout()
+		if err != nil {
+			log.Warnf("generating %s completions: %s", shell, err)
+			continue
+		}
+		docs.Completions[shell] = []byte(out)
+	}
+
+	if len(c.ManArgs) > 0 {
+		dir, err := ioutil.TempDir("", "qri_build_man")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+		args := replaceArgs(c.ManArgs, "{dir}", dir)
+		if err := (command{String: binPath, Args: args, Env: env}).Run(); err != nil {
+			return nil, fmt.Errorf("generating man pages: %s", err)
+		}
+		fis, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, fi := range fis {
+			if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".1") {
+				continue
+			}
+			if docs.ManPages[fi.Name()], err = ioutil.ReadFile(filepath.Join(dir, fi.Name())); err != nil {
+				return nil, err
+			}
+		}
+	} else if docs.ManPages, err = helpManPages(binPath, env, version, time.Now()); err != nil {
+		return nil, fmt.Errorf("generating man pages: %s", err)
+	}
+
+	return docs, nil
+}
+
+// generateDocs generates CLI docs, or returns nil with a warning when they
+// can't be generated. a failed docs build shouldn't hold up a release, which
+// ships without completions & man pages instead
+func generateDocs(qriRepoPath, version string, c DocsConfig) *CLIDocs {
+	docs, err := GenerateCLIDocs(qriRepoPath, version, c)
+	if err != nil {
+		log.Warnf("skipping shell completions & man pages: %s", err)
+		return nil
+	}
+	return docs
+}
+
+func replaceArgs(args []string, placeholder, val string) []string {
+	res := make([]string, len(args))
+	for i, a := range args {
+		res[i] = strings.Replace(a, placeholder, val, -1)
+	}
+	return res
+}
+
+// completionFilenames are the names each shell looks for completions under
+var completionFilenames = map[string]string{
+	"bash": "qri.bash",
+	"zsh":  "_qri",
+	"fish": "qri.fish",
+}
+
+// ArchiveFiles lays docs out for a zip archive, with completions in
+// completions/ & man pages in man/man1/
+func (d *CLIDocs) ArchiveFiles() []packageFile {
+	var files []packageFile
+	for _, shell := range completionShells {
+		if script, ok := d.Completions[shell]; ok {
+			files = append(files, packageFile{Path: "completions/" + completionFilenames[shell], Data: script, Mode: 0644})
+		}
+	}
+	for _, name := range d.manPageNames() {
+		files = append(files, packageFile{Path: "man/man1/" + name, Data: d.ManPages[name], Mode: 0644})
+	}
+	return files
+}
+
+// PackageFiles lays docs out for a linux package format. man pages are
+// gzipped, as distributions expect
+func (d *CLIDocs) PackageFiles(format string) ([]packageFile, error) {
+	// debian's zsh only searches vendor-completions for packaged completions
+	zshDir := "/usr/share/zsh/site-functions"
+	if format == "deb" {
+		zshDir = "/usr/share/zsh/vendor-completions"
+	}
+	dirs := map[string]string{
+		"bash": "/usr/share/bash-completion/completions/qri",
+		"zsh":  zshDir + "/_qri",
+		"fish": "/usr/share/fish/vendor_completions.d/qri.fish",
+	}
+
+	var files []packageFile
+	for _, shell := range completionShells {
+		if script, ok := d.Completions[shell]; ok {
+			files = append(files, packageFile{Path: dirs[shell], Data: script, Mode: 0644})
+		}
+	}
+	for _, name := range d.manPageNames() {
+		data, err := gzipBytes(d.ManPages[name])
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, packageFile{Path: "/usr/share/man/man1/" + name + ".gz", Data: data, Mode: 0644})
+	}
+	return files, nil
+}
+
+func (d *CLIDocs) manPageNames() []string {
+	names := make([]string, 0, len(d.ManPages))
+	for name := range d.ManPages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// cobraCommand is a subcommand listed in cobra's help output
+type cobraCommand struct {
+	Name  string
+	Short string
+}
+
+// helpManPages generates a man page for qri & each of its subcommands from
+// their --help output
+func helpManPages(binPath string, env map[string]string, version string, date time.Time) (map[string][]byte, error) {
+	pages := map[string][]byte{}
+
+	var walk func(cmdPath []string, short string) error
+	walk = func(cmdPath []string, short string) error {
+		args := append(append([]string{}, cmdPath...), "--help")
+		help, err := command{String: binPath, Args: args, Env: env}.SecretRunStdout()
+		if err != nil {
+			return fmt.Errorf("%s %s: %s", binName, strings.Join(args, " "), err)
+		}
+
+		name := strings.Join(append([]string{binName}, cmdPath...), "-")
+		subs := cobraSubcommands(help)
+		pages[name+".1"] = manPage(name, short, help, subs, version, date)
+
+		for _, sub := range subs {
+			if sub.Name == "help" || sub.Name == "completion" {
+				continue
+			}
+			if err := walk(append(append([]string{}, cmdPath...), sub.Name), sub.Short); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return pages, walk(nil, qriSummary)
+}
+
+// cobraSubcommands reads the command list sections of cobra's help output
+func cobraSubcommands(help string) (cmds []cobraCommand) {
+	inList := false
+	sc := bufio.NewScanner(strings.NewReader(help))
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case strings.HasSuffix(line, "Commands:") && !strings.HasPrefix(line, " "):
+			inList = true
+		case strings.TrimSpace(line) == "":
+			inList = false
+		case inList:
+			fields := strings.Fields(line)
+			cmds = append(cmds, cobraCommand{Name: fields[0], Short: strings.Join(fields[1:], " ")})
+		}
+	}
+	return cmds
+}
+
+// manPage formats help text as a roff man page
+func manPage(name, short, help string, subs []cobraCommand, version string, date time.Time) []byte {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, ".TH \"%s\" \"1\" \"%s\" \"qri %s\" \"Qri Manual\"\n", strings.ToUpper(name), date.Format("January 2006"), version)
+	fmt.Fprintf(buf, ".SH NAME\n%s \\- %s\n", roffEscape(name), roffEscape(short))
+	fmt.Fprintf(buf, ".SH DESCRIPTION\n.nf\n")
+	for _, line := range strings.Split(strings.TrimRight(help, "\n"), "\n") {
+		fmt.Fprintln(buf, roffLine(line))
+	}
+	fmt.Fprintf(buf, ".fi\n")
+
+	var seeAlso []string
+	if i := strings.LastIndex(name, "-"); i > 0 {
+		seeAlso = append(seeAlso, name[:i])
+	}
+	for _, sub := range subs {
+		if sub.Name != "help" && sub.Name != "completion" {
+			seeAlso = append(seeAlso, name+"-"+sub.Name)
+		}
+	}
+	if len(seeAlso) > 0 {
+		refs := make([]string, len(seeAlso))
+		for i, s := range seeAlso {
+			refs[i] = fmt.Sprintf("\\fB%s\\fP(1)", roffEscape(s))
+		}
+		fmt.Fprintf(buf, ".SH SEE ALSO\n%s\n", strings.Join(refs, ", "))
+	}
+	return buf.Bytes()
+}
+
+func roffEscape(s string) string {
+	s = strings.Replace(s, `\`, `\e`, -1)
+	return strings.Replace(s, "-", `\-`, -1)
+}
+
+// roffLine escapes a line of literal text. lines starting with a control
+// character would be read as requests
+func roffLine(line string) string {
+	line = strings.Replace(line, `\`, `\e`, -1)
+	if strings.HasPrefix(line, ".") || strings.HasPrefix(line, "'") {
+		line = `\&` + line
+	}
+	return line
+}
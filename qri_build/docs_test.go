@@ -0,0 +1,165 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+const rootHelp = `qri is a global dataset version control system built on the distributed web
+
+Usage:
+  qri [command]
+
+Dataset Commands:
+  get         Get elements of qri datasets
+  save        Save changes to a dataset
+
+Network Commands:
+  connect     Connect to the distributed web by spinning up a Qri node
+
+Additional Commands:
+  completion  Generate completion script
+  help        Help about any command
+
+Flags:
+  -h, --help   help for qri
+
+Use "qri [command] --help" for more information about a command.
+`
+
+const saveHelp = `Save is how you change a dataset, updating one or more components.
+
+Usage:
+  qri save [DATASET] [flags]
+
+Flags:
+  -m, --message string   commit message for save
+      --dry-run          simulate saving a dataset
+`
+
+const getHelp = `Get the qri dataset (except for the body).
+
+Usage:
+  qri get [COMPONENT] [DATASET] [flags]
+  qri get [command]
+
+Available Commands:
+  body        Get a dataset's body
+
+Flags:
+  -f, --format string   set output format [json, yaml, csv]
+`
+
+const getBodyHelp = `.bodies are read in pages
+\ escapes are kept as-is
+
+Usage:
+  qri get body [DATASET] [flags]
+`
+
+func TestCobraSubcommands(t *testing.T) {
+	expect := []cobraCommand{
+		{Name: "get", Short: "Get elements of qri datasets"},
+		{Name: "save", Short: "Save changes to a dataset"},
+		{Name: "connect", Short: "Connect to the distributed web by spinning up a Qri node"},
+		{Name: "completion", Short: "Generate completion script"},
+		{Name: "help", Short: "Help about any command"},
+	}
+	if got := cobraSubcommands(rootHelp); !reflect.DeepEqual(got, expect) {
+		t.Errorf("expected %+v, got %+v", expect, got)
+	}
+	if got := cobraSubcommands(getHelp); !reflect.DeepEqual(got, []cobraCommand{{Name: "body", Short: "Get a dataset's body"}}) {
+		t.Errorf("unexpected get subcommands %+v", got)
+	}
+	// flag sections & indented lines ending in "Commands:" aren't lists
+	if got := cobraSubcommands(saveHelp + "  Related Commands:\n  diff  show changes\n"); len(got) != 0 {
+		t.Errorf("expected no subcommands, got %+v", got)
+	}
+}
+
+func TestHelpManPages(t *testing.T) {
+	fake := &RecordingExecutor{Responses: map[string]FakeResponse{
+		"qri --help":          {Stdout: rootHelp},
+		"qri get --help":      {Stdout: getHelp},
+		"qri get body --help": {Stdout: getBodyHelp},
+		"qri save --help":     {Stdout: saveHelp},
+		"qri connect --help":  {Stdout: "Connect to the distributed web\n"},
+	}}
+	useExecutor(t, fake)
+
+	date := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	pages, err := helpManPages("qri", nil, "0.9.1", date)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for name := range pages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if expect := []string{"qri-connect.1", "qri-get-body.1", "qri-get.1", "qri-save.1", "qri.1"}; !reflect.DeepEqual(names, expect) {
+		t.Errorf("expected pages %v, got %v", expect, names)
+	}
+	for _, line := range fake.Lines() {
+		if strings.Contains(line, "completion") || strings.Contains(line, "qri help") {
+			t.Errorf("expected help & completion commands to be skipped, ran %q", line)
+		}
+	}
+
+	get := string(pages["qri-get.1"])
+	for _, expect := range []string{
+		`.TH "QRI-GET" "1" "June 2021" "qri 0.9.1" "Qri Manual"`,
+		`.SH NAME` + "\n" + `qri\-get \- Get elements of qri datasets`,
+		"  -f, --format string   set output format [json, yaml, csv]\n",
+		`.SH SEE ALSO` + "\n" + `\fBqri\fP(1), \fBqri\-get\-body\fP(1)`,
+	} {
+		if !strings.Contains(get, expect) {
+			t.Errorf("expected qri-get.1 to contain %q, got:\n%s", expect, get)
+		}
+	}
+
+	body := string(pages["qri-get-body.1"])
+	if !strings.Contains(body, "\\&.bodies are read in pages\n\\e escapes are kept as-is\n") {
+		t.Errorf("expected control characters & backslashes to be escaped, got:\n%s", body)
+	}
+	if !strings.Contains(string(pages["qri.1"]), `qri \- `+roffEscape(qriSummary)) {
+		t.Errorf("expected the root page to use the qri summary, got:\n%s", pages["qri.1"])
+	}
+
+	fake.Responses["qri save --help"] = FakeResponse{Err: ExitError(1)}
+	if _, err := helpManPages("qri", nil, "0.9.1", date); err == nil || !strings.Contains(err.Error(), "qri save --help") {
+		t.Errorf("expected a failed --help to name the command, got %v", err)
+	}
+}
+
+func TestGenerateDocsFailure(t *testing.T) {
+	repo := fakeQriRepo(t)
+	fake := &RecordingExecutor{Responses: map[string]FakeResponse{
+		"go env GOVERSION": {Stdout: "go1.22.0\n"},
+		"go build":         {Stderr: "build failed", Err: ExitError(1)},
+	}}
+	useExecutor(t, fake)
+
+	templates, err := LoadArchiveTemplates("", repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if templates.Docs = generateDocs(repo, templates.Version, DocsConfig{}); templates.Docs != nil {
+		t.Errorf("expected no docs when the host build fails, got %+v", templates.Docs)
+	}
+	files, err := templates.Files(Target{OS: "darwin", Arch: "amd64"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if strings.HasPrefix(f.Path, "completions/") || strings.HasPrefix(f.Path, "man/") {
+			t.Errorf("expected no docs in the archive, got %s", f.Path)
+		}
+	}
+	if len(files) == 0 {
+		t.Error("expected the archive to still be built")
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/blang/semver"
+	"github.com/spf13/cobra"
+)
+
+// DoctorCmd checks the build environment
+var DoctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "check the build environment for required tools & repos",
+	Long: `
+doctor checks that the tools qri_build shells out to are installed & new enough,
+that the IPFS node at "ipfs.api" is reachable, that GOPATH is laid out the way
+qri_build expects, and that each qri repository is checked out. For anything
+missing it suggests a fix.
+
+doctor exits with a non-zero status if a required tool is absent.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if ok := Doctor(os.Stdout); !ok {
+			os.Exit(1)
+		}
+	},
+}
+
+// toolCheck describes a program qri_build depends on
+type toolCheck struct {
+	Name     string
+	Required bool
+	// VersionCmd prints the tool's version. when empty the tool is only
+	// checked for presence on $PATH
+	VersionCmd string
+	// Probe returns the version of a tool that isn't a program on $PATH, like
+	// a service. it's used instead of VersionCmd
+	Probe func() (string, error)
+	// MinVersion is the oldest acceptable version. nil accepts any version
+	MinVersion *semver.Version
+	// GOOS limits the check to a single operating system
+	GOOS string
+	Fix  string
+}
+
+func mustVersion(v string) *semver.Version {
+	ver := semver.MustParse(v)
+	return &ver
+}
+
+var toolChecks = []toolCheck{
+	{
+		// MinVersion is read from the qri repo's go.mod when it's checked out
+		Name:       "go",
+		Required:   true,
+		VersionCmd: "go env GOVERSION",
+		Fix:        "install go from https://golang.org/dl",
+	},
+	{
+		Name:       "git",
+		Required:   true,
+		VersionCmd: "git --version",
+		MinVersion: mustVersion("2.0.0"),
+		Fix:        "install git from https://git-scm.com/downloads",
+	},
+	{
+		Name:       "node",
+		Required:   true,
+		VersionCmd: "node --version",
+		MinVersion: mustVersion("10.0.0"),
+		Fix:        "install node from https://nodejs.org",
+	},
+	{
+		Name:       "yarn",
+		Required:   true,
+		VersionCmd: "yarn --version",
+		MinVersion: mustVersion("1.0.0"),
+		Fix:        "npm install -g yarn",
+	},
+	{
+		// the node's HTTP API adds webapps & publishes releases
+		Name:  "ipfs",
+		Probe: ipfsAPIVersion,
+		Fix:   "start an IPFS node with 'ipfs daemon', or set ipfs.api in the --config file",
+	},
+	{
+		// signs apt & yum repository metadata
+		Name:       "gpg",
+		VersionCmd: "gpg --version",
+		Fix:        "install gnupg from https://gnupg.org/download",
+	},
+	{
+		Name: "codesign",
+		GOOS: "darwin",
+		Fix:  "xcode-select --install",
+	},
+	{
+		Name:       "xcrun",
+		GOOS:       "darwin",
+		VersionCmd: "xcrun --version",
+		Fix:        "xcode-select --install",
+	},
+}
+
+// qriRepos are the repositories qri_build expects to find under
+// $GOPATH/src/github.com/qri-io
+var qriRepos = []string{"qri", "desktop", "frontend", "homebrew-qri"}
+
+var versionRegexp = regexp.MustCompile(`\d+\.\d+(\.\d+)?`)
+
+// parseToolVersion pulls the first version number out of a tool's version
+// output, padding missing patch numbers
+func parseToolVersion(output string) (semver.Version, error) {
+	str := versionRegexp.FindString(output)
+	if str == "" {
+		return semver.Version{}, fmt.Errorf("no version number in output: %q", output)
+	}
+	if strings.Count(str, ".") == 1 {
+		str += ".0"
+	}
+	return semver.Make(str)
+}
+
+// Doctor checks the build environment, writing a report to w. It returns
+// false if a required tool is missing or too old
+func Doctor(w io.Writer) (ok bool) {
+	ok = true
+
+	gopath := os.Getenv("GOPATH")
+	if gopath == "" {
+		if out, err := (command{String: "go env GOPATH"}).SecretRunStdout(); err == nil {
+			gopath = strings.TrimSpace(out)
+		}
+	}
+	// GOPATH can list several workspaces, qri repos are expected in the first
+	if list := filepath.SplitList(gopath); len(list) > 0 {
+		gopath = list[0]
+	}
+	orgPath := filepath.Join(gopath, "src/github.com/qri-io")
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "STATUS\tTOOL\tREQUIRED\tFOUND")
+	for _, check := range toolChecks {
+		if check.GOOS != "" && check.GOOS != runtime.GOOS {
+			continue
+		}
+		if check.Name == "go" && cfg.Toolchain.Managed {
+			// builds download the go version qri requires
+			fmt.Fprintf(tw, "ok\tgo\tmanaged\tdownloaded on demand\n")
+			continue
+		}
+		if check.Name == "go" && gopath != "" {
+			if ver, err := RequiredGoVersion(filepath.Join(orgPath, "qri")); err == nil {
+				check.MinVersion = &ver
+			}
+		}
+
+		required := "any"
+		if check.MinVersion != nil {
+			required = ">= " + check.MinVersion.String()
+		}
+		if !check.Required {
+			required += " (optional)"
+		}
+
+		found, err := checkTool(check)
+		status := "ok"
+		if err != nil {
+			status = "missing"
+			if check.Required {
+				ok = false
+			} else {
+				status = "warn"
+			}
+			found = fmt.Sprintf("%s. fix: %s", err, check.Fix)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", status, check.Name, required, found)
+	}
+	tw.Flush()
+	fmt.Fprintln(w)
+
+	if gopath == "" {
+		fmt.Fprintln(w, "warn    GOPATH is not set. fix: export GOPATH=$(go env GOPATH)")
+		return ok
+	}
+	fmt.Fprintf(w, "GOPATH: %s\n", gopath)
+
+	for _, repo := range qriRepos {
+		path := filepath.Join(orgPath, repo)
+		if fi, err := os.Stat(path); err != nil || !fi.IsDir() {
+			fmt.Fprintf(w, "warn    %s not found at %s. fix: git clone https://github.com/qri-io/%s %s\n", repo, path, repo, path)
+			continue
+		}
+		fmt.Fprintf(w, "ok      %s found at %s\n", repo, path)
+	}
+
+	return ok
+}
+
+// checkTool returns the version of an installed tool, or an error if the
+// tool is absent or too old
+func checkTool(check toolCheck) (string, error) {
+	if check.VersionCmd == "" && check.Probe == nil {
+		path, err := exec.LookPath(check.Name)
+		if err != nil {
+			return "", fmt.Errorf("not found")
+		}
+		return path, nil
+	}
+
+	var (
+		output string
+		err    error
+	)
+	if check.Probe != nil {
+		if output, err = check.Probe(); err != nil {
+			return "", err
+		}
+	} else if output, err = (command{String: check.VersionCmd}).SecretRunStdout(); err != nil {
+		return "", fmt.Errorf("not found")
+	}
+	ver, err := parseToolVersion(output)
+	if err != nil {
+		return "", err
+	}
+	if check.MinVersion != nil && ver.LT(*check.MinVersion) {
+		return "", fmt.Errorf("version %s is too old", ver)
+	}
+	return ver.String(), nil
+}
+
+// ipfsAPIVersion asks the IPFS node at the configured API address for its
+// version
+func ipfsAPIVersion() (string, error) {
+	client, err := NewIPFSClient(cfg.IPFS.API)
+	if err != nil {
+		return "", err
+	}
+	ver, err := client.Version()
+	if err != nil {
+		return "", fmt.Errorf("no IPFS API at %s", client.URL)
+	}
+	return ver, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// doctorResponses are canned version outputs for every tool doctor runs
+var doctorResponses = map[string]FakeResponse{
+	"go env GOVERSION": {Stdout: "go1.16.5\n"},
+	"git --version":    {Stdout: "git version 2.30.1\n"},
+	"node --version":   {Stdout: "v14.17.0\n"},
+	"yarn --version":   {Stdout: "1.22.10\n"},
+	"gpg --version":    {Stdout: "gpg (GnuPG) 2.2.27\nlibgcrypt 1.9.4\n"},
+	"xcrun --version":  {Stdout: "xcrun version 48.\n"},
+}
+
+// useIPFSAPI serves api & points the configured IPFS API address at it
+func useIPFSAPI(t *testing.T, api *FakeIPFSAPI) {
+	t.Helper()
+	s := httptest.NewServer(api)
+	t.Cleanup(s.Close)
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.IPFS.API = "/ip4/" + u.Hostname() + "/tcp/" + u.Port()
+}
+
+// doctorReportLine returns the line of a doctor report for a tool
+func doctorReportLine(out, tool string) string {
+	for _, line := range strings.Split(out, "\n") {
+		if fields := strings.Fields(line); len(fields) > 1 && fields[1] == tool {
+			return line
+		}
+	}
+	return ""
+}
+
+func TestDoctor(t *testing.T) {
+	rec := &RecordingExecutor{Responses: doctorResponses}
+	useExecutor(t, rec)
+	useIPFSAPI(t, NewFakeIPFSAPI())
+
+	first, second := t.TempDir(), t.TempDir()
+	orgPath := filepath.Join(first, "src/github.com/qri-io")
+	writeFiles(t, orgPath, map[string]string{"qri/go.mod": "module github.com/qri-io/qri\n\ngo 1.16\n"})
+	t.Setenv("GOPATH", strings.Join([]string{first, second}, string(os.PathListSeparator)))
+
+	buf := &bytes.Buffer{}
+	if !Doctor(buf) {
+		t.Errorf("expected doctor to pass")
+	}
+	out := buf.String()
+
+	if line := doctorReportLine(out, "ipfs"); !strings.HasPrefix(line, "ok") || !strings.Contains(line, "0.9.1") {
+		t.Errorf("expected the ipfs node's API version to be reported, got:\n%s", out)
+	}
+	if line := doctorReportLine(out, "gpg"); !strings.HasPrefix(line, "ok") || !strings.Contains(line, "2.2.27") {
+		t.Errorf("expected gpg to be checked, got:\n%s", out)
+	}
+	if line := doctorReportLine(out, "go"); !strings.Contains(line, ">= 1.16.0") {
+		t.Errorf("expected go version to be read from the qri go.mod, got:\n%s", out)
+	}
+	if doctorReportLine(out, "zip") != "" {
+		t.Errorf("expected no zip check, got:\n%s", out)
+	}
+	if !strings.Contains(out, "GOPATH: "+first+"\n") {
+		t.Errorf("expected the first GOPATH entry to be checked, got:\n%s", out)
+	}
+	if expect := "ok      qri found at " + filepath.Join(orgPath, "qri"); !strings.Contains(out, expect) {
+		t.Errorf("expected %q, got:\n%s", expect, out)
+	}
+}
+
+func TestDoctorIPFSUnreachable(t *testing.T) {
+	useExecutor(t, &RecordingExecutor{Responses: doctorResponses})
+	s := httptest.NewServer(NewFakeIPFSAPI())
+	u, _ := url.Parse(s.URL)
+	s.Close()
+	cfg.IPFS.API = "/ip4/" + u.Hostname() + "/tcp/" + u.Port()
+	t.Setenv("GOPATH", t.TempDir())
+
+	buf := &bytes.Buffer{}
+	if !Doctor(buf) {
+		t.Errorf("an unreachable IPFS node shouldn't fail doctor")
+	}
+	if line := doctorReportLine(buf.String(), "ipfs"); !strings.HasPrefix(line, "warn") || !strings.Contains(line, "ipfs daemon") {
+		t.Errorf("expected an ipfs warning with a fix, got:\n%s", buf.String())
+	}
+}
+
+func TestDoctorManagedToolchain(t *testing.T) {
+	rec := &RecordingExecutor{Responses: map[string]FakeResponse{
+		"go env GOVERSION": {Err: ExitError(1)},
+	}}
+	for line, res := range doctorResponses {
+		if line != "go env GOVERSION" {
+			rec.Responses[line] = res
+		}
+	}
+	useExecutor(t, rec)
+	useIPFSAPI(t, NewFakeIPFSAPI())
+	cfg.Toolchain.Managed = true
+	t.Setenv("GOPATH", t.TempDir())
+
+	buf := &bytes.Buffer{}
+	if !Doctor(buf) {
+		t.Errorf("expected doctor to pass without go on $PATH, got:\n%s", buf.String())
+	}
+	if line := doctorReportLine(buf.String(), "go"); !strings.HasPrefix(line, "ok") || !strings.Contains(line, "managed") {
+		t.Errorf("expected the go check to be skipped, got:\n%s", buf.String())
+	}
+	for _, line := range rec.Lines() {
+		if line == "go env GOVERSION" {
+			t.Errorf("expected go on $PATH not to be run")
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+)
+
+// ElectronCmd is the old name of DesktopCmd, kept so existing build scripts
+// keep working. --frontend maps onto desktop's --desktop flag
+var ElectronCmd = &cobra.Command{
+	Use:        "electron",
+	Short:      "build the qri electron app (use 'desktop')",
+	Deprecated: "use 'qri_build desktop --desktop <path>' instead. --frontend is the same as --desktop",
+	Long: `
+electron builds the qri electron app from the frontend repo. It's the same as
+'qri_build desktop', which replaced it when the electron app moved to the desktop
+repo: --frontend is passed along as --desktop.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		frontendPath, err := cmd.Flags().GetString("frontend")
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		qriPath, err := cmd.Flags().GetString("qri")
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		noUpdate, err := cmd.Flags().GetBool("no-update-source")
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		publish, err := cmd.Flags().GetBool("publish")
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		// check here so errors name electron's flags, not desktop's
+		if qriPath == "" || frontendPath == "" {
+			log.Error("Flags --qri and --frontend are both required")
+			return
+		}
+		if err := DesktopBuildPackage(frontendPath, qriPath, !noUpdate, publish, nil, nil); err != nil {
+			log.Errorf("%s", err)
+		}
+	},
+}
+
+func init() {
+	ElectronCmd.Flags().String("frontend", "", "path to qri frontend repo containing the electron app")
+	ElectronCmd.Flags().String("qri", "", "path to qri repository")
+	ElectronCmd.Flags().Bool("no-update-source", false, "don't switch & pull master branches")
+	ElectronCmd.Flags().Bool("publish", false, "publish the installer to a draft github release. requires $"+githubTokenEnvVar)
+}
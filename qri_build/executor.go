@@ -0,0 +1,24 @@
+package main
+
+import (
+	"os/exec"
+)
+
+// Executor runs prepared commands. All external programs qri_build calls
+// (go, git, yarn, ipfs, ...) go through the package-level executor, which
+// makes it possible to swap in a fake
+type Executor interface {
+	Run(cmd *exec.Cmd) error
+}
+
+// executor is the Executor used by command. defaults to running commands
+// for real
+var executor Executor = ExecExecutor{}
+
+// ExecExecutor runs commands with os/exec
+type ExecExecutor struct{}
+
+// Run executes cmd
+func (ExecExecutor) Run(cmd *exec.Cmd) error {
+	return cmd.Run()
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// RecordedCommand is a command captured by a RecordingExecutor
+type RecordedCommand struct {
+	// Line is the command name & arguments joined with spaces
+	Line string
+	Dir  string
+	Env  []string
+}
+
+// FakeResponse is the canned result of a command run by a RecordingExecutor
+type FakeResponse struct {
+	Stdout string
+	Stderr string
+	// Err is returned from Run. Use ExitError to simulate a command that
+	// ran and failed
+	Err error
+}
+
+// RecordingExecutor is a fake Executor that records every command instead
+// of running it, replying with canned responses. It lets build flows run
+// without go, git or yarn installed
+type RecordingExecutor struct {
+	// Responses maps a command line prefix to a canned response. When more
+	// than one prefix matches, the longest wins
+	Responses map[string]FakeResponse
+	// Fallback runs commands that have no matching response. When nil,
+	// unmatched commands succeed without output
+	Fallback Executor
+
+	lk       sync.Mutex
+	commands []RecordedCommand
+}
+
+// Run records cmd & writes its canned response
+func (r *RecordingExecutor) Run(cmd *exec.Cmd) error {
+	line := strings.Join(cmd.Args, " ")
+	r.lk.Lock()
+	r.commands = append(r.commands, RecordedCommand{
+		Line: line,
+		Dir:  cmd.Dir,
+		Env:  cmd.Env,
+	})
+	r.lk.Unlock()
+
+	res, ok := r.response(line)
+	if !ok {
+		if r.Fallback != nil {
+			return r.Fallback.Run(cmd)
+		}
+		return nil
+	}
+
+	if res.Stdout != "" && cmd.Stdout != nil {
+		if _, err := io.WriteString(cmd.Stdout, res.Stdout); err != nil {
+			return err
+		}
+	}
+	if res.Stderr != "" && cmd.Stderr != nil {
+		if _, err := io.WriteString(cmd.Stderr, res.Stderr); err != nil {
+			return err
+		}
+	}
+	return res.Err
+}
+
+func (r *RecordingExecutor) response(line string) (res FakeResponse, ok bool) {
+	matched := -1
+	for prefix, resp := range r.Responses {
+		if strings.HasPrefix(line, prefix) && len(prefix) > matched {
+			res, ok, matched = resp, true, len(prefix)
+		}
+	}
+	return
+}
+
+// Commands returns all recorded commands in the order they were run
+func (r *RecordingExecutor) Commands() []RecordedCommand {
+	r.lk.Lock()
+	defer r.lk.Unlock()
+	return append([]RecordedCommand(nil), r.commands...)
+}
+
+// Lines returns the command lines of all recorded commands
+func (r *RecordingExecutor) Lines() []string {
+	cmds := r.Commands()
+	lines := make([]string, len(cmds))
+	for i, c := range cmds {
+		lines[i] = c.Line
+	}
+	return lines
+}
+
+// ExitError is an error for a command that ran & exited with a non-zero
+// status. Fakes return it to stand in for *exec.ExitError
+type ExitError int
+
+// Error implements the error interface
+func (e ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
+// ExitCode returns the exit status
+func (e ExitError) ExitCode() int {
+	return int(e)
+}
+
+// useExecutor swaps the package executor & configuration for the duration of
+// a test
+func useExecutor(t *testing.T, e Executor) {
+	t.Helper()
+	prevExec, prevCfg := executor, cfg
+	executor, cfg = e, DefaultConfig()
+	t.Cleanup(func() { executor, cfg = prevExec, prevCfg })
+}
+
+// chdir changes the working directory for the duration of a test
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// writeZip creates a zip archive at path holding empty files
+func writeZip(t *testing.T, path string, names ...string) {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for _, name := range names {
+		if _, err := zw.Create(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fakeQriRepo creates a qri source tree with just enough for qri_build to
+// read its version & go.mod
+func fakeQriRepo(t *testing.T) string {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":             "module github.com/qri-io/qri\n\ngo 1.16\n",
+		"version/version.go": "package version\n\nconst String = \"0.9.1\"\n",
+	})
+	return dir
+}
+
+const mitLicense = `MIT License
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software.
+`
+
+// fakeGoResponses are canned go toolchain responses for a qri binary with a
+// single MIT licensed dependency in modCache
+func fakeGoResponses(t *testing.T, modCache string) map[string]FakeResponse {
+	writeFiles(t, modCache, map[string]string{"github.com/blang/semver@v3.5.1+incompatible/LICENSE": mitLicense})
+	return map[string]FakeResponse{
+		"go env GOVERSION":         {Stdout: "go1.22.0\n"},
+		"go env GOMODCACHE GOPATH": {Stdout: modCache + "\n" + modCache + "\n"},
+		"go version -m":            {Stdout: "qri: go1.22.0\n\tpath\tgithub.com/qri-io/qri\n\tmod\tgithub.com/qri-io/qri\t(devel)\t\n\tdep\tgithub.com/blang/semver\tv3.5.1+incompatible\th1:abc=\n"},
+		"go build":                 {},
+	}
+}
+
+func TestRecordingExecutor(t *testing.T) {
+	fake := &RecordingExecutor{Responses: map[string]FakeResponse{
+		"git":           {Stdout: "generic"},
+		"git rev-parse": {Stdout: "specific"},
+		"git push":      {Stderr: "rejected", Err: ExitError(1)},
+	}}
+	useExecutor(t, fake)
+
+	out, err := command{String: "git rev-parse --abbrev-ref HEAD", Dir: "/repo"}.SecretRunStdout()
+	if err != nil || out != "specific" {
+		t.Errorf("expected the longest matching prefix to win, got %q, %v", out, err)
+	}
+	if err := (command{String: "git push"}).Run(); err == nil || err.(ExitError).ExitCode() != 1 {
+		t.Errorf("expected exit status 1, got %v", err)
+	}
+	if err := (command{String: "yarn"}).Run(); err != nil {
+		t.Errorf("expected unmatched commands to succeed, got %s", err)
+	}
+
+	cmds := fake.Commands()
+	if len(cmds) != 3 || cmds[0].Dir != "/repo" {
+		t.Fatalf("unexpected commands: %v", cmds)
+	}
+	expect := []string{"git rev-parse --abbrev-ref HEAD", "git push", "yarn"}
+	if got := fake.Lines(); strings.Join(got, "\n") != strings.Join(expect, "\n") {
+		t.Errorf("expected lines %q, got %q", expect, got)
+	}
+}
+
+func TestParseGoVersion(t *testing.T) {
+	cases := []struct {
+		in, out string
+		err     bool
+	}{
+		{"go1.13", "1.13.0", false},
+		{"1.21.3", "1.21.3", false},
+		{"go1.22rc1", "1.22.0-rc1", false},
+		{" go1.16.15\n", "1.16.15", false},
+		{"devel", "", true},
+	}
+	for _, c := range cases {
+		v, err := parseGoVersion(c.in)
+		if c.err {
+			if err == nil {
+				t.Errorf("%q: expected an error", c.in)
+			}
+			continue
+		}
+		if err != nil || v.String() != c.out {
+			t.Errorf("%q: expected %s, got %s (%v)", c.in, c.out, v, err)
+		}
+	}
+}
+
+func TestEnsureGoEnvVars(t *testing.T) {
+	repo := fakeQriRepo(t)
+	cases := []struct {
+		goversion string
+		err       string
+	}{
+		{"go1.22.0\n", ""},
+		{"go1.16\n", ""},
+		{"go1.15.2\n", "below the version required"},
+		{"\n", "older than 1.16"},
+	}
+	for _, c := range cases {
+		fake := &RecordingExecutor{Responses: map[string]FakeResponse{"go env GOVERSION": {Stdout: c.goversion}}}
+		useExecutor(t, fake)
+		err := ensureGoEnvVars(repo)
+		if c.err == "" && err != nil {
+			t.Errorf("%q: unexpected error: %s", c.goversion, err)
+		} else if c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("%q: expected error containing %q, got %v", c.goversion, c.err, err)
+		}
+		if lines := fake.Lines(); len(lines) != 1 || lines[0] != "go env GOVERSION" {
+			t.Errorf("expected go to be asked for its version, got %q", lines)
+		}
+	}
+}
+
+func TestBuildQri(t *testing.T) {
+	repo := fakeQriRepo(t)
+	fake := &RecordingExecutor{Responses: fakeGoResponses(t, t.TempDir())}
+	useExecutor(t, fake)
+	cfg.Targets = map[string]BuildProfile{"linux": {Tags: []string{"netgo"}, Ldflags: "-s -w"}}
+	chdir(t, t.TempDir())
+
+	target := Target{OS: "linux", Arch: "arm", Variant: "v7"}
+	dir, err := BuildQri(target, repo, "-X main.webapp=/ipfs/x")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var build *RecordedCommand
+	for _, c := range fake.Commands() {
+		if strings.HasPrefix(c.Line, "go build") {
+			c := c
+			build = &c
+		}
+	}
+	if build == nil {
+		t.Fatalf("go build wasn't run: %q", fake.Lines())
+	}
+	expect := fmt.Sprintf("go build -o %s -tags netgo -ldflags -s -w -X main.webapp=/ipfs/x", filepath.Join(dir, "qri"))
+	if build.Line != expect {
+		t.Errorf("expected build line:\n%s\ngot:\n%s", expect, build.Line)
+	}
+	if build.Dir != repo {
+		t.Errorf("expected build to run in %s, got %s", repo, build.Dir)
+	}
+	env := strings.Join(build.Env, " ")
+	for _, kv := range []string{"GOOS=linux", "GOARCH=arm", "GOARM=7", "CGO_ENABLED=0"} {
+		if !strings.Contains(env, kv) {
+			t.Errorf("expected build env to contain %s", kv)
+		}
+	}
+
+	sbom := &SBOM{}
+	data, err := ioutil.ReadFile(filepath.Join(dir, sbomFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, sbom); err != nil {
+		t.Fatal(err)
+	}
+	if len(sbom.Components) != 1 || sbom.Components[0].Name != "github.com/blang/semver" {
+		t.Errorf("unexpected sbom components: %v", sbom.Components)
+	}
+	licenses, err := ioutil.ReadFile(filepath.Join(dir, thirdPartyLicensesFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(licenses), "github.com/blang/semver") || !strings.Contains(string(licenses), "Permission is hereby granted") {
+		t.Errorf("expected third party licenses to include semver's MIT license, got:\n%s", licenses)
+	}
+}
+
+func TestDesktopBuildPackage(t *testing.T) {
+	repo := fakeQriRepo(t)
+	// go build is faked, so the binary it would write already exists
+	writeFiles(t, repo, map[string]string{"build/qri": "qri binary"})
+	desktop := t.TempDir()
+	writeFiles(t, desktop, map[string]string{
+		"package.json":                     `{"name": "qri-desktop", "version": "0.5.0"}`,
+		"yarn.lock":                        "\"left-pad@^1.3.0\":\n  version \"1.3.0\"\n  resolved \"https://registry.yarnpkg.com/left-pad/-/left-pad-1.3.0.tgz\"\n  integrity sha512-abc==\n",
+		"release/Qri Desktop.dmg":          "dmg",
+		"release/Qri Desktop.dmg.blockmap": "blockmap",
+	})
+	if err := os.Mkdir(filepath.Join(desktop, "backend"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := &RecordingExecutor{Responses: fakeGoResponses(t, t.TempDir())}
+	useExecutor(t, fake)
+	cfg.Codesign.Signer = "fake"
+	chdir(t, t.TempDir())
+
+	prev := os.Getenv(githubTokenEnvVar)
+	os.Setenv(githubTokenEnvVar, "token")
+	defer os.Setenv(githubTokenEnvVar, prev)
+
+	if err := DesktopBuildPackage(desktop, repo, false, true, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var lines []string
+	for _, c := range fake.Commands() {
+		switch {
+		case strings.HasPrefix(c.Line, "yarn"):
+			if c.Dir != desktop {
+				t.Errorf("expected %q to run in the desktop repo, ran in %q", c.Line, c.Dir)
+			}
+			lines = append(lines, c.Line)
+		case strings.HasPrefix(c.Line, "go build"):
+			if c.Dir != repo {
+				t.Errorf("expected go build to run in the qri repo, ran in %q", c.Dir)
+			}
+			lines = append(lines, c.Line)
+		}
+	}
+	installer, err := filepath.Abs(filepath.Join("output", "Qri Desktop.dmg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{
+		"go build -o build/qri",
+		"yarn",
+		// electron-builder mustn't publish before the installer is signed
+		"yarn dist --publish never",
+		"yarn electron-builder publish --files " + installer,
+	}
+	if strings.Join(lines, "\n") != strings.Join(expect, "\n") {
+		t.Errorf("expected commands:\n%s\ngot:\n%s", strings.Join(expect, "\n"), strings.Join(lines, "\n"))
+	}
+
+	backend, err := ioutil.ReadFile(filepath.Join(desktop, "backend", "qri"))
+	if err != nil || string(backend) != "qri binary" {
+		t.Errorf("expected qri binary to be copied into the desktop backend: %v", err)
+	}
+
+	m, err := LoadManifest("output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := map[string]string{}
+	for _, a := range m.Artifacts {
+		kinds[a.Name] = a.Kind
+		if a.Name == "Qri Desktop.dmg" && (a.Signature == nil || !a.Signature.Notarized) {
+			t.Errorf("expected the dmg to be signed & notarized, got %v", a.Signature)
+		}
+	}
+	if kinds["Qri Desktop.dmg"] != ArtifactInstaller || kinds["Qri Desktop.cdx.json"] != ArtifactSBOM {
+		t.Errorf("unexpected manifest artifacts: %v", kinds)
+	}
+}
+
+func TestHomebrewBuildInstaller(t *testing.T) {
+	repo := fakeQriRepo(t)
+	gopath := t.TempDir()
+	tap := filepath.Join(gopath, "src/github.com/qri-io/homebrew-qri")
+	if err := os.MkdirAll(tap, 0755); err != nil {
+		t.Fatal(err)
+	}
+	prev := os.Getenv("GOPATH")
+	os.Setenv("GOPATH", gopath)
+	defer os.Setenv("GOPATH", prev)
+
+	zipPath := filepath.Join(t.TempDir(), "qri_darwin_amd64.zip")
+	writeZip(t, zipPath, "qri", "completions/qri.bash", "completions/_qri", "man/man1/qri.1")
+
+	fake := &RecordingExecutor{}
+	useExecutor(t, fake)
+	if err := HomebrewBuildInstaller(repo, zipPath, false); err != nil {
+		t.Fatal(err)
+	}
+	if lines := fake.Lines(); len(lines) != 0 {
+		t.Errorf("expected the formula to be written without running commands, ran %q", lines)
+	}
+
+	formula, err := ioutil.ReadFile(filepath.Join(tap, "qri.rb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`  url "https://github.com/qri-io/qri/releases/download/v0.9.1/qri_darwin_amd64.zip"`,
+		`    bin.install "qri"`,
+		`    bash_completion.install "completions/qri.bash" => "qri"`,
+		`    zsh_completion.install "completions/_qri"`,
+		`    man1.install Dir["man/man1/*.1"]`,
+	} {
+		if !strings.Contains(string(formula), line+"\n") {
+			t.Errorf("expected formula to contain %q, got:\n%s", line, formula)
+		}
+	}
+	// fish completions failed to generate
+	if strings.Contains(string(formula), "fish_completion") {
+		t.Errorf("expected formula not to install missing fish completions:\n%s", formula)
+	}
+
+	writeFiles(t, repo, map[string]string{"version/version.go": "package version\n\nconst String = \"0.9.2-dev\"\n"})
+	if err := HomebrewBuildInstaller(repo, zipPath, false); err == nil {
+		t.Error("expected publishing a dev version to fail")
+	}
+
+	writeZip(t, zipPath, "README.md")
+	if err := HomebrewBuildInstaller(repo, zipPath, false); err == nil {
+		t.Error("expected a zip without a qri binary to fail")
+	}
+}
@@ -8,4 +8,4 @@ require (
 	github.com/spf13/pflag v1.0.3 // indirect
 )
 
-go 1.13
+go 1.16
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/blang/semver"
+)
+
+var goVersionRegexp = regexp.MustCompile(`^(\d+)\.(\d+)(?:\.(\d+))?(.*)$`)
+
+// parseGoVersion converts a go release name like "go1.13", "1.21.3" or
+// "go1.22rc1" to a semantic version
+func parseGoVersion(v string) (semver.Version, error) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "go")
+	m := goVersionRegexp.FindStringSubmatch(v)
+	if m == nil {
+		return semver.Version{}, fmt.Errorf("invalid go version: %q", v)
+	}
+	patch := m[3]
+	if patch == "" {
+		patch = "0"
+	}
+	str := fmt.Sprintf("%s.%s.%s", m[1], m[2], patch)
+	if pre := strings.TrimLeft(m[4], "-"); pre != "" {
+		str += "-" + pre
+	}
+	return semver.Make(str)
+}
+
+// RequiredGoVersion reads the minimum go toolchain needed to build the module
+// at repoPath from the `go` & `toolchain` directives of its go.mod, returning
+// whichever is newer
+func RequiredGoVersion(repoPath string) (ver semver.Version, err error) {
+	f, err := os.Open(filepath.Join(repoPath, "go.mod"))
+	if err != nil {
+		return ver, err
+	}
+	defer f.Close()
+
+	found := false
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 || (fields[0] != "go" && fields[0] != "toolchain") {
+			continue
+		}
+		v, err := parseGoVersion(fields[1])
+		if err != nil {
+			return ver, fmt.Errorf("reading %s directive of %s/go.mod: %s", fields[0], repoPath, err)
+		}
+		if !found || v.GT(ver) {
+			ver = v
+			found = true
+		}
+	}
+	if err = s.Err(); err != nil {
+		return ver, err
+	}
+	if !found {
+		return ver, fmt.Errorf("%s/go.mod has no go directive", repoPath)
+	}
+	return ver, nil
+}
+
+// localGoVersion returns the version of the go toolchain on $PATH
+func localGoVersion() (semver.Version, error) {
+	output, err := command{String: "go env GOVERSION"}.RunStdout()
+	if err != nil {
+		return semver.Version{}, err
+	}
+	output = strings.TrimSpace(output)
+	if output == "" {
+		// GOVERSION was added in go 1.16
+		return semver.Version{}, fmt.Errorf("'go env GOVERSION' is empty, go is older than 1.16")
+	}
+	return parseGoVersion(output)
+}
+
+// ensureGoEnvVars checks the local go toolchain is new enough to build the
+// module at repoPath & that go modules aren't disabled
+func ensureGoEnvVars(repoPath string) error {
+	required, err := RequiredGoVersion(repoPath)
+	if err != nil {
+		return err
+	}
+
+	goVersion, err := localGoVersion()
+	if err != nil {
+		return fmt.Errorf("invalid output from 'go env GOVERSION': %s", err)
+	}
+
+	if goVersion.LT(required) {
+		return fmt.Errorf("go version %s is below the version required by %s/go.mod: %s", goVersion, repoPath, required)
+	}
+
+	// modules are on by default since go 1.16, only an explicit opt-out breaks
+	// the build
+	if os.Getenv("GO111MODULE") == "off" {
+		return fmt.Errorf("Error: envvar `GO111MODULE` is `off`, qri requires go modules")
+	}
+
+	return nil
+}
+
+// goBinary returns the go command to build the module at repoPath with. When
+// toolchains are managed it's a cached toolchain pinned to the version go.mod
+// requires, otherwise it's the go on $PATH, checked to be new enough
+func goBinary(repoPath string) (string, error) {
+	if !cfg.Toolchain.Managed {
+		return "go", ensureGoEnvVars(repoPath)
+	}
+
+	required, err := RequiredGoVersion(repoPath)
+	if err != nil {
+		return "", err
+	}
+	cache, err := newToolchainCache(cfg.Toolchain)
+	if err != nil {
+		return "", err
+	}
+	return cache.GoBin(required)
+}
+
+// goEnv adds variables that stop go from switching away from a managed
+// toolchain to the passed environment. GOROOT is dropped, a managed toolchain
+// finds its own & the user's would point it at another go's standard library
+func goEnv(env map[string]string) map[string]string {
+	if cfg.Toolchain.Managed {
+		env["GOTOOLCHAIN"] = "local"
+		delete(env, "GOROOT")
+	}
+	return env
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"archive/zip"
+	"bytes"
 	"crypto/sha256"
 	"fmt"
 	"io/ioutil"
@@ -56,7 +58,7 @@ class Qri < Formula
   sha256 "$SHA256"
 
   def install
-    bin.install "qri"
+$INSTALL
   end
 
   test do
@@ -95,23 +97,20 @@ func HomebrewBuildInstaller(srcPath, zipFile string, ignoreDevRestriction bool)
 	sum := sha256.Sum256(data)
 	hashDigest := fmt.Sprintf("%x", sum)
 
+	// Only install the docs that made it into the zip file.
+	install, err := homebrewInstallLines(data)
+	if err != nil {
+		return fmt.Errorf("reading %s: %s", zipFile, err)
+	}
+
 	// Get filename for the zip file that is being released.
 	zipBasename := path.Base(zipFile)
 
-	// Read the sourcefile that contains the current version number.
-	libSourcefile := filepath.Join(srcPath, "version/version.go")
-	data, err = ioutil.ReadFile(libSourcefile)
+	// Read the current version number from source.
+	versionNum, err := QriVersion(srcPath)
 	if err != nil {
 		return err
 	}
-	codeText := string(data)
-	// Parse the version number from the sourcefile.
-	versionLine, err := grep(codeText, "const String")
-	if err != nil {
-		return err
-	}
-	versionParts := strings.Split(versionLine, " ")
-	versionNum := strings.Replace(versionParts[3], "\"", "", -1)
 
 	// It is an error to publish a development version.
 	if strings.Contains(versionNum, "-dev") && !ignoreDevRestriction {
@@ -123,6 +122,7 @@ func HomebrewBuildInstaller(srcPath, zipFile string, ignoreDevRestriction bool)
 	content = strings.Replace(content, "$VERSION", versionNum, -1)
 	content = strings.Replace(content, "$ZIPFILE", zipBasename, -1)
 	content = strings.Replace(content, "$SHA256", hashDigest, -1)
+	content = strings.Replace(content, "$INSTALL", strings.Join(install, "\n"), -1)
 
 	// Publish to the homebrew repo.
 	formulaPath := filepath.Join(homebrewRepo, "qri.rb")
@@ -135,6 +135,66 @@ func HomebrewBuildInstaller(srcPath, zipFile string, ignoreDevRestriction bool)
 	return nil
 }
 
+// homebrewFormulaCompletions are the formula methods that install each
+// shell's completions
+var homebrewFormulaCompletions = map[string]string{
+	"bash": `bash_completion.install "completions/qri.bash" => "qri"`,
+	"zsh":  `zsh_completion.install "completions/_qri"`,
+	"fish": `fish_completion.install "completions/qri.fish"`,
+}
+
+// homebrewInstallLines lists the formula's install steps for the files in a
+// release zip. completions & man pages are optional, the binary isn't
+func homebrewInstallLines(zipData []byte) ([]string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(zipData), int64(len(zipData)))
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	hasMan := false
+	for _, f := range zr.File {
+		names[f.Name] = true
+		if strings.HasPrefix(f.Name, "man/man1/") && strings.HasSuffix(f.Name, ".1") {
+			hasMan = true
+		}
+	}
+	if !names[binName] {
+		return nil, fmt.Errorf("archive has no %s binary", binName)
+	}
+
+	lines := []string{`    bin.install "qri"`}
+	for _, shell := range completionShells {
+		if names["completions/"+completionFilenames[shell]] {
+			lines = append(lines, "    "+homebrewFormulaCompletions[shell])
+		}
+	}
+	if hasMan {
+		lines = append(lines, `    man1.install Dir["man/man1/*.1"]`)
+	}
+	return lines, nil
+}
+
+// QriVersion reads the version number of the qri source at srcPath
+func QriVersion(srcPath string) (string, error) {
+	// Read the sourcefile that contains the current version number.
+	libSourcefile := filepath.Join(srcPath, "version/version.go")
+	data, err := ioutil.ReadFile(libSourcefile)
+	if err != nil {
+		return "", err
+	}
+	codeText := string(data)
+	// Parse the version number from the sourcefile.
+	versionLine, err := grep(codeText, "const String")
+	if err != nil {
+		return "", err
+	}
+	versionParts := strings.Split(versionLine, " ")
+	if len(versionParts) < 4 {
+		return "", fmt.Errorf("unexpected version line in %s: %q", libSourcefile, versionLine)
+	}
+	return strings.Replace(versionParts[3], "\"", "", -1), nil
+}
+
 func grep(haystack, needle string) (string, error) {
 	lines := strings.Split(haystack, "\n")
 	for _, ln := range lines {
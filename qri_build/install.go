@@ -0,0 +1,350 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// InstallCmd downloads & installs a qri release
+var InstallCmd = &cobra.Command{
+	Use:   "install [version]",
+	Short: "download, verify & install a qri release",
+	Long: `
+install downloads the qri release archive for this machine, checks it & installs
+the qri binary to --dir.
+
+Releases are found through their manifest.json. Without a version the manifest at
+"install.latest" in the --config file is used, with one "{version}" is replaced
+in "install.release". --index reads a manifest from any URL instead, including
+a latest.json written by 'qri_build index'.
+
+The archive's size & sha256 must match the manifest. When a public key is given
+with --pubkey or "signing.publicKey", the signatures qri_build sign wrote for the
+manifest & the archive must verify too.
+
+The binary is written to a temporary file next to its destination & renamed into
+place, so an interrupted install never leaves a broken qri behind.
+`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		indexURL, err := cmd.Flags().GetString("index")
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		if indexURL == "" {
+			indexURL = cfg.Install.Latest
+			if len(args) == 1 {
+				indexURL = strings.Replace(cfg.Install.Release, "{version}", args[0], -1)
+			}
+		}
+
+		dir, err := cmd.Flags().GetString("dir")
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		if dir == "" {
+			dir = defaultInstallDir()
+		}
+
+		targetStr, err := cmd.Flags().GetString("target")
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		target := hostTarget()
+		if targetStr != "" {
+			if target, err = ParseTarget(targetStr); err != nil {
+				log.Error(err)
+				return
+			}
+		}
+
+		pubKeyStr, err := cmd.Flags().GetString("pubkey")
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		if pubKeyStr == "" {
+			pubKeyStr = cfg.Signing.PublicKey
+		}
+		var pub *PublicKey
+		if pubKeyStr != "" {
+			if pub, err = LoadPublicKey(pubKeyStr); err != nil {
+				log.Error(err)
+				os.Exit(1)
+			}
+		} else {
+			log.Warnf("no public key configured. only checksums will be verified")
+		}
+
+		inst := &Installer{Client: http.DefaultClient, PublicKey: pub}
+		res, err := inst.Install(indexURL, target, dir)
+		if err != nil {
+			log.Errorf("installing qri: %s", err)
+			os.Exit(1)
+		}
+		fmt.Printf("qri %s was installed successfully to %s\n", res.Version, res.Path)
+		printPathGuidance(dir, res.Path)
+	},
+}
+
+func init() {
+	InstallCmd.Flags().String("index", "", "URL of a release manifest.json. overrides [version]")
+	InstallCmd.Flags().String("dir", "", "directory to install qri to. defaults to $QRI_INSTALL/bin or /usr/local/bin")
+	InstallCmd.Flags().String("target", "", "os/arch[/variant] to install. defaults to this machine")
+	InstallCmd.Flags().String("pubkey", "", "public key, or path to a public key file, to verify signatures with")
+}
+
+// InstallConfig configures where 'qri_build install' finds releases
+type InstallConfig struct {
+	// Latest is the URL of the latest release's manifest
+	Latest string `json:"latest"`
+	// Release is the URL of a release's manifest, with "{version}" in place
+	// of the release version
+	Release string `json:"release"`
+}
+
+// default release manifest locations. github redirects "latest/download" to
+// the newest release's assets
+const (
+	DefaultInstallLatest  = "https://github.com/qri-io/qri/releases/latest/download/manifest.json"
+	DefaultInstallRelease = "https://github.com/qri-io/qri/releases/download/{version}/manifest.json"
+)
+
+// Installer downloads, verifies & installs qri releases
+type Installer struct {
+	Client *http.Client
+	// PublicKey verifies release signatures. nil skips signature checks
+	PublicKey *PublicKey
+}
+
+// InstallResult describes an installed release
+type InstallResult struct {
+	Version  string
+	Artifact *Artifact
+	// Path is the installed qri binary
+	Path string
+}
+
+// Install reads the release manifest at indexURL & installs the archive built
+// for target into dir
+func (inst *Installer) Install(indexURL string, target Target, dir string) (*InstallResult, error) {
+	base, err := url.Parse(indexURL)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := inst.fetch(base)
+	if err != nil {
+		return nil, fmt.Errorf("fetching release manifest: %s", err)
+	}
+	if err := inst.verify(base, data); err != nil {
+		return nil, err
+	}
+	m := &Manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("parsing release manifest: %s", err)
+	}
+
+	a, err := m.Archive(target)
+	if err != nil {
+		return nil, err
+	}
+	name := a.Name
+	if a.URL != "" {
+		name = a.URL
+	}
+	ref, err := url.Parse(name)
+	if err != nil {
+		return nil, err
+	}
+	archiveURL := base.ResolveReference(ref)
+
+	log.Infof("downloading qri %s for %s from %s", m.Version, a.Target, archiveURL)
+	data, err = inst.fetch(archiveURL)
+	if err != nil {
+		return nil, fmt.Errorf("downloading %s: %s", a.Name, err)
+	}
+	if int64(len(data)) != a.Size {
+		return nil, fmt.Errorf("%s: expected %d bytes, got %d", a.Name, a.Size, len(data))
+	}
+	if sum := fmt.Sprintf("%x", sha256.Sum256(data)); sum != a.SHA256 {
+		return nil, fmt.Errorf("%s: checksum mismatch. expected %s, got %s", a.Name, a.SHA256, sum)
+	}
+	if err := inst.verify(archiveURL, data); err != nil {
+		return nil, err
+	}
+
+	t, err := ParseTarget(a.Target)
+	if err != nil {
+		return nil, err
+	}
+	bin, err := unzipFile(data, t.BinName())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", a.Name, err)
+	}
+	path := filepath.Join(dir, t.BinName())
+	if err := installFile(path, bin, 0755); err != nil {
+		return nil, err
+	}
+	return &InstallResult{Version: m.Version, Artifact: a, Path: path}, nil
+}
+
+// Archive returns the release archive to install on target. archives built
+// for an architecture variant match a target without one, preferring the
+// least demanding variant
+func (m *Manifest) Archive(target Target) (*Artifact, error) {
+	var candidates []*Artifact
+	variants := map[*Artifact]string{}
+	for _, a := range m.Artifacts {
+		if a.Kind != ArtifactArchive {
+			continue
+		}
+		t, err := ParseTarget(a.Target)
+		if err != nil || !t.Matches(target) {
+			continue
+		}
+		candidates = append(candidates, a)
+		variants[a] = t.Variant
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("release has no archive for %s", target)
+	}
+	sort.Slice(candidates, func(i, j int) bool { return variants[candidates[i]] < variants[candidates[j]] })
+	return candidates[0], nil
+}
+
+// fetch GETs a URL, failing on non-200 responses
+func (inst *Installer) fetch(u *url.URL) ([]byte, error) {
+	res, err := inst.Client.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", u, res.Status)
+	}
+	return ioutil.ReadAll(res.Body)
+}
+
+// verify checks the detached signature published next to u
+func (inst *Installer) verify(u *url.URL, data []byte) error {
+	if inst.PublicKey == nil {
+		return nil
+	}
+	sigURL := *u
+	sigURL.Path += sigExt
+	sig, err := inst.fetch(&sigURL)
+	if err != nil {
+		return fmt.Errorf("fetching signature: %s", err)
+	}
+	if err := inst.PublicKey.Verify(data, sig); err != nil {
+		return fmt.Errorf("%s: %s", filepath.Base(u.Path), err)
+	}
+	return nil
+}
+
+// unzipFile reads a single file from the root of a zip archive
+func unzipFile(data []byte, name string) ([]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range zr.File {
+		if f.Name != name {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return ioutil.ReadAll(rc)
+	}
+	return nil, fmt.Errorf("archive has no %s", name)
+}
+
+// installFile atomically replaces path with data. the file is written to a
+// temporary file in the same directory & renamed over path
+func installFile(path string, data []byte, mode os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+
+	if _, err := io.Copy(f, bytes.NewReader(data)); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// hostTarget is the target of this machine. amd64 builds running under
+// Rosetta on apple silicon report arm64
+func hostTarget() Target {
+	t := Target{OS: runtime.GOOS, Arch: runtime.GOARCH}
+	if t.OS == "darwin" && t.Arch == "amd64" {
+		translated, err := command{String: "sysctl -n sysctl.proc_translated"}.SecretRunStdout()
+		if err == nil && strings.TrimSpace(translated) == "1" {
+			t.Arch = "arm64"
+		}
+	}
+	return t
+}
+
+func defaultInstallDir() string {
+	if dir := os.Getenv("QRI_INSTALL"); dir != "" {
+		return filepath.Join(dir, "bin")
+	}
+	return "/usr/local/bin"
+}
+
+// printPathGuidance explains how to run qri when dir isn't on $PATH
+func printPathGuidance(dir, path string) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		abs = dir
+	}
+	for _, p := range filepath.SplitList(os.Getenv("PATH")) {
+		if p, err := filepath.Abs(p); err == nil && p == abs {
+			fmt.Println("Run 'qri --help' to get started")
+			return
+		}
+	}
+	fmt.Printf(`%s isn't on your $PATH. Manually add it to your $HOME/.bash_profile (or similar):
+  export PATH="%s:$PATH"
+Run '%s --help' to get started
+`, abs, abs, path)
+}
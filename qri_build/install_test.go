@@ -0,0 +1,215 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testQriBinary is the binary packed into test release archives
+const testQriBinary = "#!/bin/sh\necho qri 0.9.1\n"
+
+// writeTestRelease writes a signed release directory with an archive for
+// each target & returns its signing key
+func writeTestRelease(t *testing.T, dir string, targets ...Target) *SigningKey {
+	t.Helper()
+	m, err := LoadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Version = "0.9.1"
+	for _, target := range targets {
+		buf := &bytes.Buffer{}
+		zw := zip.NewWriter(buf)
+		w, err := zw.Create(target.BinName())
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(testQriBinary))
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, "qri_"+target.Name()+".zip")
+		if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		target := target
+		if _, err := m.Add(path, ArtifactArchive, &target, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SignRelease(dir, key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// serveRelease serves dir over http, returning the manifest's URL
+func serveRelease(t *testing.T, dir string) string {
+	t.Helper()
+	s := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	t.Cleanup(s.Close)
+	return s.URL + "/" + manifestFilename
+}
+
+func TestInstall(t *testing.T) {
+	release := t.TempDir()
+	linux := Target{OS: "linux", Arch: "amd64"}
+	key := writeTestRelease(t, release, linux, Target{OS: "darwin", Arch: "arm64"})
+	url := serveRelease(t, release)
+
+	dir := t.TempDir()
+	inst := &Installer{Client: http.DefaultClient, PublicKey: key.Public()}
+	res, err := inst.Install(url, linux, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Version != "0.9.1" || res.Artifact.Target != "linux/amd64" {
+		t.Errorf("unexpected install result %+v", res)
+	}
+	if res.Path != filepath.Join(dir, "qri") {
+		t.Errorf("expected qri to be installed to %s, got %s", dir, res.Path)
+	}
+	data, err := ioutil.ReadFile(res.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != testQriBinary {
+		t.Errorf("unexpected installed binary %q", data)
+	}
+	if fi, err := os.Stat(res.Path); err != nil || fi.Mode().Perm() != 0755 {
+		t.Errorf("expected an executable binary, got %v (%v)", fi.Mode(), err)
+	}
+	if tmps, _ := filepath.Glob(filepath.Join(dir, ".qri.tmp*")); len(tmps) != 0 {
+		t.Errorf("expected temporary files to be cleaned up, found %v", tmps)
+	}
+}
+
+func TestInstallRejectsBadArchive(t *testing.T) {
+	linux := Target{OS: "linux", Arch: "amd64"}
+	cases := []struct {
+		description string
+		tamper      func(t *testing.T, release string, key *SigningKey)
+		err         string
+	}{
+		{"sha256 mismatch", func(t *testing.T, release string, key *SigningKey) {
+			// same size, different bytes
+			path := filepath.Join(release, "qri_linux_amd64.zip")
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data[len(data)-1] ^= 0xff
+			if err := ioutil.WriteFile(path, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}, "checksum mismatch"},
+		{"size mismatch", func(t *testing.T, release string, key *SigningKey) {
+			f, err := os.OpenFile(filepath.Join(release, "qri_linux_amd64.zip"), os.O_APPEND|os.O_WRONLY, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Write([]byte("trailing"))
+			f.Close()
+		}, "bytes"},
+		{"archive signed by another key", func(t *testing.T, release string, key *SigningKey) {
+			other, err := GenerateSigningKey()
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := ioutil.ReadFile(filepath.Join(release, "qri_linux_amd64.zip"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := writeSignature(release, "qri_linux_amd64.zip", data, other); err != nil {
+				t.Fatal(err)
+			}
+		}, "qri_linux_amd64.zip"},
+		{"corrupt manifest signature", func(t *testing.T, release string, key *SigningKey) {
+			if err := ioutil.WriteFile(filepath.Join(release, manifestFilename+sigExt), []byte("untrusted comment: x\nnot a signature\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}, manifestFilename},
+		{"missing signature", func(t *testing.T, release string, key *SigningKey) {
+			if err := os.Remove(filepath.Join(release, "qri_linux_amd64.zip"+sigExt)); err != nil {
+				t.Fatal(err)
+			}
+		}, "fetching signature"},
+	}
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			release := t.TempDir()
+			key := writeTestRelease(t, release, linux)
+			c.tamper(t, release, key)
+
+			dir := t.TempDir()
+			inst := &Installer{Client: http.DefaultClient, PublicKey: key.Public()}
+			_, err := inst.Install(serveRelease(t, release), linux, dir)
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Fatalf("expected error containing %q, got %v", c.err, err)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "qri")); !os.IsNotExist(err) {
+				t.Errorf("expected nothing to be installed, got %v", err)
+			}
+		})
+	}
+}
+
+func TestInstallNoMatchingTarget(t *testing.T) {
+	release := t.TempDir()
+	key := writeTestRelease(t, release, Target{OS: "linux", Arch: "amd64"})
+
+	inst := &Installer{Client: http.DefaultClient, PublicKey: key.Public()}
+	_, err := inst.Install(serveRelease(t, release), Target{OS: "freebsd", Arch: "amd64"}, t.TempDir())
+	if err == nil || !strings.Contains(err.Error(), "no archive for freebsd/amd64") {
+		t.Errorf("expected a missing target error, got %v", err)
+	}
+}
+
+func TestManifestArchive(t *testing.T) {
+	m := &Manifest{Artifacts: []*Artifact{
+		{Name: "qri_linux_amd64.deb", Kind: ArtifactPackage, Target: "linux/amd64"},
+		{Name: "qri_linux_arm_v7.zip", Kind: ArtifactArchive, Target: "linux/arm/v7"},
+		{Name: "qri_linux_arm_v6.zip", Kind: ArtifactArchive, Target: "linux/arm/v6"},
+		{Name: "qri_linux_amd64.zip", Kind: ArtifactArchive, Target: "linux/amd64"},
+		{Name: "qri_windows_amd64.zip", Kind: ArtifactArchive, Target: "windows/amd64"},
+	}}
+	cases := []struct {
+		target Target
+		name   string
+	}{
+		{Target{OS: "linux", Arch: "amd64"}, "qri_linux_amd64.zip"},
+		{Target{OS: "linux", Arch: "arm", Variant: "v7"}, "qri_linux_arm_v7.zip"},
+		// without a variant, the least demanding build wins
+		{Target{OS: "linux", Arch: "arm"}, "qri_linux_arm_v6.zip"},
+		{Target{OS: "windows", Arch: "amd64"}, "qri_windows_amd64.zip"},
+		{Target{OS: "darwin", Arch: "amd64"}, ""},
+		{Target{OS: "linux", Arch: "arm64"}, ""},
+	}
+	for _, c := range cases {
+		a, err := m.Archive(c.target)
+		if c.name == "" {
+			if err == nil {
+				t.Errorf("%s: expected no archive, got %s", c.target, a.Name)
+			}
+			continue
+		}
+		if err != nil || a.Name != c.name {
+			t.Errorf("%s: expected %s, got %v (%v)", c.target, c.name, a, err)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+)
+
+// InstallScriptCmd generates install.sh for a release directory
+var InstallScriptCmd = &cobra.Command{
+	Use:   "install-script",
+	Short: "generate install.sh for the archives in a release directory",
+	Long: `
+install-script writes install.sh to the release directory. The script embeds the
+name & sha256 of every linux, darwin & freebsd archive in the directory's
+manifest.json, picks one for the machine it runs on with 'uname', and checks the
+download against its sha256 before unpacking it.
+
+Archives are downloaded from --base-url, which defaults to the github release
+for the manifest's version. Set QRI_INSTALL_BASE_URL when running the script to
+download from somewhere else.
+
+The script is rendered from install.sh.tmpl in --templates, or the template
+built into qri_build.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		dir, err := releaseDir(cmd)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		baseURL, err := cmd.Flags().GetString("base-url")
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		templatesDir, err := cmd.Flags().GetString("templates")
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		path, err := WriteInstallScript(dir, baseURL, templatesDir)
+		if err != nil {
+			log.Errorf("writing install script: %s", err)
+			return
+		}
+		fmt.Printf("wrote %s\n", path)
+	},
+}
+
+func init() {
+	InstallScriptCmd.Flags().String("dir", "", releaseDirUsage)
+	InstallScriptCmd.Flags().String("base-url", "", "URL archives are downloaded from. defaults to the github release for the manifest version")
+	InstallScriptCmd.Flags().String("templates", "", "path to templates directory containing install.sh.tmpl. defaults to built-in templates")
+}
+
+// installScriptFilename is the name of the generated install script
+const installScriptFilename = "install.sh"
+
+// installScriptOS are the operating systems install.sh supports
+var installScriptOS = map[string]bool{"darwin": true, "linux": true, "freebsd": true}
+
+// InstallScriptData is passed to the install.sh template
+type InstallScriptData struct {
+	Version string
+	BaseURL string
+	Assets  []InstallScriptAsset
+}
+
+// InstallScriptAsset is an archive install.sh can download
+type InstallScriptAsset struct {
+	// Target is written os/arch[/variant]
+	Target string
+	Name   string
+	SHA256 string
+}
+
+// WriteInstallScript renders install.sh for the archives listed in dir's
+// manifest & records it in the manifest
+func WriteInstallScript(dir, baseURL, templatesDir string) (string, error) {
+	m, err := LoadManifest(dir)
+	if err != nil {
+		return "", err
+	}
+	if baseURL == "" {
+		if m.Version == "" {
+			return "", fmt.Errorf("manifest has no version. set --base-url")
+		}
+		baseURL = fmt.Sprintf("https://github.com/qri-io/qri/releases/download/v%s", m.Version)
+	}
+
+	data := InstallScriptData{Version: m.Version, BaseURL: strings.TrimSuffix(baseURL, "/")}
+	for _, a := range m.Artifacts {
+		if a.Kind != ArtifactArchive {
+			continue
+		}
+		t, err := ParseTarget(a.Target)
+		if err != nil || !installScriptOS[t.OS] {
+			continue
+		}
+		data.Assets = append(data.Assets, InstallScriptAsset{Target: t.String(), Name: a.Name, SHA256: a.SHA256})
+	}
+	if len(data.Assets) == 0 {
+		return "", fmt.Errorf("no archives for %s in %s", installScriptTargets(), filepath.Join(dir, manifestFilename))
+	}
+	sort.Slice(data.Assets, func(i, j int) bool { return data.Assets[i].Target < data.Assets[j].Target })
+
+	tmpl, err := loadInstallScriptTemplate(templatesDir)
+	if err != nil {
+		return "", err
+	}
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, data); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, installScriptFilename)
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0755); err != nil {
+		return "", err
+	}
+	if _, err := m.Add(path, ArtifactScript, nil, nil); err != nil {
+		return "", err
+	}
+	return path, m.Save()
+}
+
+func loadInstallScriptTemplate(templatesDir string) (*template.Template, error) {
+	var (
+		src []byte
+		err error
+	)
+	if templatesDir != "" {
+		src, err = ioutil.ReadFile(filepath.Join(templatesDir, installScriptFilename+".tmpl"))
+	} else {
+		src, err = fs.ReadFile(embeddedTemplates, "templates/"+installScriptFilename+".tmpl")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading install script template: %s", err)
+	}
+	return template.New(installScriptFilename).Parse(string(src))
+}
+
+func installScriptTargets() string {
+	names := make([]string, 0, len(installScriptOS))
+	for name := range installScriptOS {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
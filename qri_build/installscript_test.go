@@ -0,0 +1,100 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// runInstallScript writes install.sh for the release in dir, serves the
+// release & runs the script, installing into prefix
+func runInstallScript(t *testing.T, dir, prefix string) (string, error) {
+	t.Helper()
+	s := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	defer s.Close()
+
+	script, err := WriteInstallScript(dir, s.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("sh", script)
+	cmd.Env = append(os.Environ(), "QRI_INSTALL="+prefix)
+	out, err := cmd.CombinedOutput()
+	return string(out), err
+}
+
+func skipUnlessInstallScriptRuns(t *testing.T) {
+	if !installScriptOS[runtime.GOOS] {
+		t.Skipf("install.sh doesn't support %s", runtime.GOOS)
+	}
+	for _, tools := range [][]string{{"sh"}, {"unzip"}, {"curl", "wget"}, {"sha256sum", "shasum"}} {
+		found := false
+		for _, tool := range tools {
+			if _, err := exec.LookPath(tool); err == nil {
+				found = true
+			}
+		}
+		if !found {
+			t.Skipf("install.sh needs %s", strings.Join(tools, " or "))
+		}
+	}
+}
+
+func TestInstallScript(t *testing.T) {
+	skipUnlessInstallScriptRuns(t)
+	release, prefix := t.TempDir(), t.TempDir()
+	writeTestRelease(t, release, hostTarget(), Target{OS: "windows", Arch: "amd64"})
+
+	out, err := runInstallScript(t, release, prefix)
+	if err != nil {
+		t.Fatalf("running install.sh: %s\n%s", err, out)
+	}
+	bin := filepath.Join(prefix, "bin", "qri")
+	data, err := ioutil.ReadFile(bin)
+	if err != nil {
+		t.Fatalf("expected qri to be installed: %s\n%s", err, out)
+	}
+	if string(data) != testQriBinary {
+		t.Errorf("unexpected installed binary %q", data)
+	}
+	if !strings.Contains(out, "Qri 0.9.1 was installed successfully to "+bin) {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+
+	m, err := LoadManifest(release)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range m.Artifacts {
+		if a.Name == installScriptFilename && a.Kind != ArtifactScript {
+			t.Errorf("expected install.sh to be recorded as a script, got %s", a.Kind)
+		}
+	}
+}
+
+func TestInstallScriptRejectsTamperedArchive(t *testing.T) {
+	skipUnlessInstallScriptRuns(t)
+	release, prefix := t.TempDir(), t.TempDir()
+	target := hostTarget()
+	writeTestRelease(t, release, target)
+
+	// replace the archive after the manifest recorded its checksum
+	writeZip(t, filepath.Join(release, "qri_"+target.Name()+".zip"), "qri")
+
+	out, err := runInstallScript(t, release, prefix)
+	if err == nil {
+		t.Fatalf("expected install.sh to fail:\n%s", out)
+	}
+	if !strings.Contains(out, "Checksum mismatch") {
+		t.Errorf("expected a checksum mismatch, got:\n%s", out)
+	}
+	if _, err := os.Stat(filepath.Join(prefix, "bin", "qri")); !os.IsNotExist(err) {
+		t.Errorf("expected nothing to be installed, got %v", err)
+	}
+}
@@ -1,11 +1,246 @@
 package main
 
-// IPFSAdd adds the given file to IPFS & returns the root CID
-func IPFSAdd(path string) (hash string, err error) {
-	return command{
-		String: "ipfs add -rQ %s",
-		Tmpl: []interface{}{
-			path,
-		},
-	}.RunStdout()
+import (
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// IPFSCmd groups commands that publish to IPFS
+var IPFSCmd = &cobra.Command{
+	Use:   "ipfs",
+	Short: "publish release artifacts to IPFS",
+}
+
+// IPFSPublishCmd adds a release directory to IPFS
+var IPFSPublishCmd = &cobra.Command{
+	Use:   "publish",
+	Short: "add a release directory to IPFS, pin it & update an IPNS name",
+	Long: `
+publish adds the --dir directory tree to an IPFS node & prints its root CID. The
+node is reached through its HTTP API at --api, "ipfs.api" in the --config file, or
+` + DefaultIPFSAPI + ` by default.
+
+With --pin the CID is also pinned to the pinning service API configured in the
+"ipfs.pinning" section of the --config file, authenticated by "ipfs.pinning.token"
+or the ` + PinningTokenEnvVar + ` environment variable.
+
+With --ipns-key (or "ipfs.ipnsKey") the named IPNS key, eg. "webapp", is updated
+to point at the new CID.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		dir, err := cmd.Flags().GetString("dir")
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		pin, err := cmd.Flags().GetBool("pin")
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		name, err := cmd.Flags().GetString("name")
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		ipnsKey, err := cmd.Flags().GetString("ipns-key")
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		if ipnsKey == "" {
+			ipnsKey = cfg.IPFS.IPNSKey
+		}
+
+		if name == "" {
+			abs, err := filepath.Abs(dir)
+			if err != nil {
+				log.Error(err)
+				return
+			}
+			name = filepath.Base(abs)
+		}
+
+		var pinning *PinningConfig
+		if pin {
+			pinning = &cfg.IPFS.Pinning
+		}
+
+		apiAddr, err := cmd.Flags().GetString("api")
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		if apiAddr == "" {
+			apiAddr = cfg.IPFS.API
+		}
+		client, err := NewIPFSClient(apiAddr)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		res, err := IPFSPublish(client, dir, name, pinning, ipnsKey)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		fmt.Printf("published %s to /ipfs/%s\n", dir, res.CID)
+		if res.IPNSName != "" {
+			fmt.Printf("updated /ipns/%s\n", res.IPNSName)
+		}
+	},
+}
+
+func init() {
+	IPFSPublishCmd.Flags().String("dir", "output", "release directory to publish")
+	IPFSPublishCmd.Flags().Bool("pin", false, "pin the published CID to the configured pinning service")
+	IPFSPublishCmd.Flags().String("name", "", "name for the pin. defaults to the directory name")
+	IPFSPublishCmd.Flags().String("ipns-key", "", "IPNS key to point at the published CID")
+	IPFSCmd.PersistentFlags().String("api", "", "multiaddr of the IPFS node's HTTP API")
+	IPFSCmd.AddCommand(IPFSPublishCmd)
+}
+
+// IPFSConfig configures publishing to IPFS
+type IPFSConfig struct {
+	// API is the multiaddr of the IPFS node's HTTP API
+	API string `json:"api"`
+	// Pinning is a pinning service API to pin published content to
+	Pinning PinningConfig `json:"pinning"`
+	// IPNSKey is the name of the node's IPNS key updated on publish
+	IPNSKey string `json:"ipnsKey"`
+}
+
+// IPFSPublishResult describes published content
+type IPFSPublishResult struct {
+	CID string
+	// CumulativeSize is the size of the published DAG
+	CumulativeSize uint64
+	// IPNSName is the IPNS name updated to point at CID, if any
+	IPNSName string
+}
+
+// IPFSPublish adds a directory tree to IPFS, optionally pinning it to a
+// pinning service & publishing it under an IPNS key
+func IPFSPublish(client *IPFSClient, dir, name string, pinning *PinningConfig, ipnsKey string) (*IPFSPublishResult, error) {
+	obj, err := client.Add(dir, logProgress(dir))
+	if err != nil {
+		return nil, fmt.Errorf("adding %s to ipfs: %s", dir, err)
+	}
+	log.Infof("added %s as %s (%d bytes)", dir, obj.CID, obj.CumulativeSize)
+	res := &IPFSPublishResult{CID: obj.CID, CumulativeSize: obj.CumulativeSize}
+
+	if pinning != nil {
+		if err := PinRemote(*pinning, obj.CID, name); err != nil {
+			return nil, fmt.Errorf("pinning %s: %s", obj.CID, err)
+		}
+	}
+
+	if ipnsKey != "" {
+		if res.IPNSName, err = client.NamePublish(ipnsKey, obj.CID); err != nil {
+			return nil, fmt.Errorf("publishing %s to ipns: %s", obj.CID, err)
+		}
+	}
+	return res, nil
+}
+
+// multihash codes of the hash functions IPFS uses
+var multihashLengths = map[uint64]uint64{
+	0x12:   32, // sha2-256
+	0x13:   64, // sha2-512
+	0x16:   32, // sha3-256
+	0xb220: 32, // blake2b-256
+}
+
+// ParseCID trims surrounding whitespace from s & checks that the result is a
+// valid CID
+func ParseCID(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if err := ValidateCID(s); err != nil {
+		return "", err
+	}
+	return s, nil
+}
+
+// ValidateCID checks that s is a well-formed CIDv0 (base58 "Qm...") or CIDv1
+// in base32 ("b...") or base58 ("z...") multibase
+func ValidateCID(s string) error {
+	var (
+		data []byte
+		err  error
+	)
+	switch {
+	case len(s) == 46 && strings.HasPrefix(s, "Qm"):
+		if data, err = base58Decode(s); err != nil {
+			return err
+		}
+		return validateMultihash(data)
+	case strings.HasPrefix(s, "b"):
+		data, err = base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(s[1:]))
+	case strings.HasPrefix(s, "z"):
+		data, err = base58Decode(s[1:])
+	default:
+		return fmt.Errorf("invalid CID")
+	}
+	if err != nil {
+		return fmt.Errorf("invalid CID: %s", err)
+	}
+
+	version, n := binary.Uvarint(data)
+	if n <= 0 || version != 1 {
+		return fmt.Errorf("invalid CID version")
+	}
+	data = data[n:]
+	if _, n = binary.Uvarint(data); n <= 0 {
+		return fmt.Errorf("invalid CID codec")
+	}
+	return validateMultihash(data[n:])
+}
+
+func validateMultihash(data []byte) error {
+	code, n := binary.Uvarint(data)
+	if n <= 0 {
+		return fmt.Errorf("invalid multihash")
+	}
+	data = data[n:]
+	length, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data[n:])) != length {
+		return fmt.Errorf("invalid multihash length")
+	}
+	if want, ok := multihashLengths[code]; ok && want != length {
+		return fmt.Errorf("invalid multihash length")
+	}
+	return nil
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// base58Decode decodes bitcoin-alphabet base58, as used by CIDv0
+func base58Decode(s string) ([]byte, error) {
+	n := new(big.Int)
+	radix := big.NewInt(58)
+	for _, r := range s {
+		i := strings.IndexRune(base58Alphabet, r)
+		if i < 0 {
+			return nil, fmt.Errorf("invalid base58 character %q", r)
+		}
+		n.Mul(n, radix)
+		n.Add(n, big.NewInt(int64(i)))
+	}
+
+	// leading '1's encode leading zero bytes
+	zeros := 0
+	for zeros < len(s) && s[zeros] == '1' {
+		zeros++
+	}
+	return append(make([]byte, zeros), n.Bytes()...), nil
 }
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/base32"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// base58Encode encodes data in bitcoin-alphabet base58
+func base58Encode(data []byte) string {
+	n := new(big.Int).SetBytes(data)
+	radix, mod := big.NewInt(58), new(big.Int)
+	var out []byte
+	for n.Sign() > 0 {
+		n.DivMod(n, radix, mod)
+		out = append([]byte{base58Alphabet[mod.Int64()]}, out...)
+	}
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		out = append([]byte{'1'}, out...)
+	}
+	return string(out)
+}
+
+// base32NoPad encodes data in the lowercase, unpadded base32 CIDv1 uses
+func base32NoPad(data []byte) string {
+	return strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(data))
+}
+
+func TestBase58Decode(t *testing.T) {
+	for _, data := range [][]byte{{}, {0}, {0, 0, 1}, {0x12, 0x20, 0xff}, []byte("qri")} {
+		got, err := base58Decode(base58Encode(data))
+		if err != nil {
+			t.Errorf("%x: %s", data, err)
+			continue
+		}
+		if string(got) != string(data) {
+			t.Errorf("round trip mismatch. expected %x, got %x", data, got)
+		}
+	}
+	if _, err := base58Decode("0OIl"); err == nil {
+		t.Error("expected characters outside the alphabet to fail")
+	}
+}
+
+func TestParseCID(t *testing.T) {
+	// base58btc CIDv1 of the same dag-pb node as the base32 CID below
+	cidv1Base58 := "zdj7WWeQ43G6JJvLWQWZpyHuAMq6uYWRjkBXFad11vE2LHhQ7"
+	cases := []struct {
+		input, expect, err string
+	}{
+		{"QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbdG", "QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbdG", ""},
+		{"bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi", "bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi", ""},
+		{cidv1Base58, cidv1Base58, ""},
+		// identity multihash of empty content
+		{"bafkqaaa", "bafkqaaa", ""},
+		{"QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbdG\n", "QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbdG", ""},
+		{"  bafkqaaa\t\r\n", "bafkqaaa", ""},
+
+		{"", "", "invalid CID"},
+		{"\n", "", "invalid CID"},
+		{"Qm", "", "invalid CID"},
+		{"hello", "", "invalid CID"},
+		// 0 isn't in the base58 alphabet
+		{"QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbd0", "", "invalid base58"},
+		{"bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzd", "", "invalid multihash length"},
+		{"b!!!", "", "invalid CID"},
+		// CIDv2 doesn't exist
+		{"b" + base32NoPad([]byte{2, 0x70, 0x00, 0x00}), "", "invalid CID version"},
+		// a sha2-256 multihash with a 31 byte digest
+		{"z" + base58Encode(append([]byte{1, 0x55, 0x12, 31}, make([]byte, 31)...)), "", "invalid multihash length"},
+	}
+	for _, c := range cases {
+		got, err := ParseCID(c.input)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%q: expected error containing %q, got %v", c.input, c.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", c.input, err)
+			continue
+		}
+		if got != c.expect {
+			t.Errorf("%q: expected %q, got %q", c.input, c.expect, got)
+		}
+		if err := ValidateCID(got); err != nil {
+			t.Errorf("%q: parsed CID doesn't validate: %s", c.input, err)
+		}
+	}
+}
+
+func TestIPFSPublish(t *testing.T) {
+	api := NewFakeIPFSAPI()
+	client := newTestIPFSClient(t, api)
+	svc := &fakePinningService{Token: "secret", Polls: 1, Result: "pinned"}
+	pinning := servePinning(t, svc)
+
+	dir := filepath.Join(t.TempDir(), "output")
+	writeFiles(t, dir, map[string]string{
+		"qri_linux_amd64.zip": "zip",
+		"manifest.json":       "{}",
+		"apt/pool/qri.deb":    "deb",
+	})
+	cid, err := client.Hash(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := IPFSPublish(client, dir, "qri 0.9.1", &pinning, "webapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.CID != cid {
+		t.Errorf("expected the directory's CID %s, got %s", cid, res.CID)
+	}
+	if _, ok := api.Objects[cid]; !ok {
+		t.Errorf("expected %s to be stored on the node", cid)
+	}
+	if res.CumulativeSize != 8 {
+		t.Errorf("expected a cumulative size of 8, got %d", res.CumulativeSize)
+	}
+	if pins := svc.Pins(); len(pins) != 1 || pins[0].Pin.CID != cid || pins[0].Pin.Name != "qri 0.9.1" {
+		t.Errorf("expected %s to be pinned, got %+v", cid, pins)
+	}
+	if res.IPNSName != "k51webapp" || api.Names["webapp"] != "/ipfs/"+cid {
+		t.Errorf("expected the webapp key to point at %s, got %s -> %s", cid, res.IPNSName, api.Names["webapp"])
+	}
+
+	// without pinning or a key, content is only added
+	other := filepath.Join(t.TempDir(), "other")
+	writeFiles(t, other, map[string]string{"a.txt": "a"})
+	if res, err = IPFSPublish(client, other, "", nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	if res.IPNSName != "" || len(svc.Pins()) != 1 || api.Names["webapp"] != "/ipfs/"+cid {
+		t.Errorf("expected nothing to be pinned or published, got %+v", res)
+	}
+
+	svc.lk.Lock()
+	svc.Result = "failed"
+	svc.lk.Unlock()
+	if _, err := IPFSPublish(client, other, "", &pinning, ""); err == nil || !strings.Contains(err.Error(), "pinning") {
+		t.Errorf("expected a failed pin to fail publishing, got %v", err)
+	}
+}
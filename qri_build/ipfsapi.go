@@ -0,0 +1,364 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultIPFSAPI is the multiaddr of a local IPFS node's HTTP API
+const DefaultIPFSAPI = "/ip4/127.0.0.1/tcp/5001"
+
+// IPFSClient talks to an IPFS node's HTTP API
+type IPFSClient struct {
+	// URL is the base URL of the API, eg. http://127.0.0.1:5001
+	URL  string
+	HTTP *http.Client
+	// Retry re-attempts requests that fail for transient reasons. nil makes
+	// a single attempt
+	Retry *retryPolicy
+}
+
+// NewIPFSClient creates a client for the API listening on a multiaddr
+func NewIPFSClient(apiAddr string) (*IPFSClient, error) {
+	if apiAddr == "" {
+		apiAddr = DefaultIPFSAPI
+	}
+	u, err := multiaddrURL(apiAddr)
+	if err != nil {
+		return nil, err
+	}
+	return &IPFSClient{URL: u, HTTP: http.DefaultClient, Retry: ipfsRetryPolicy}, nil
+}
+
+// multiaddrURL converts an API multiaddr like /ip4/127.0.0.1/tcp/5001 or
+// /dns4/ipfs.example.com/tcp/443/https to a URL
+func multiaddrURL(addr string) (string, error) {
+	parts := strings.Split(strings.Trim(addr, "/"), "/")
+	if len(parts) < 4 || parts[2] != "tcp" {
+		return "", fmt.Errorf("unsupported IPFS API address %q. expected /ip4|ip6|dns|dns4|dns6/<host>/tcp/<port>", addr)
+	}
+
+	host := parts[1]
+	switch parts[0] {
+	case "ip4", "ip6":
+		if net.ParseIP(host) == nil {
+			return "", fmt.Errorf("invalid IP in IPFS API address %q", addr)
+		}
+	case "dns", "dns4", "dns6":
+	default:
+		return "", fmt.Errorf("unsupported IPFS API address %q. expected /ip4|ip6|dns|dns4|dns6/<host>/tcp/<port>", addr)
+	}
+
+	scheme := "http"
+	if len(parts) == 5 && (parts[4] == "http" || parts[4] == "https") {
+		scheme = parts[4]
+	} else if len(parts) > 4 {
+		return "", fmt.Errorf("unsupported IPFS API address %q", addr)
+	}
+	return fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(host, parts[3])), nil
+}
+
+// IPFSObject describes content added to or stored on an IPFS node
+type IPFSObject struct {
+	CID string
+	// Size is the size of the file contents, zero for directories
+	Size uint64
+	// CumulativeSize is the size of the content & all the blocks linking it
+	CumulativeSize uint64
+}
+
+// Add adds a file or directory tree to the node, pinning it, & returns the
+// root object. progress, if set, is called with the number of bytes read so
+// far & the total to add. the returned CID is validated & checked against
+// the node's own record of the content
+func (c *IPFSClient) Add(path string, progress func(done, total int64)) (*IPFSObject, error) {
+	var obj *IPFSObject
+	err := c.retry("add", func() (err error) {
+		obj, err = c.add(path, url.Values{"pin": {"true"}, "progress": {"true"}}, progress)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	stat, err := c.Stat(obj.CID)
+	if err != nil {
+		return nil, fmt.Errorf("reading back %s: %s", obj.CID, err)
+	}
+	if stat.CID != obj.CID {
+		return nil, fmt.Errorf("ipfs node stored %s as %s", obj.CID, stat.CID)
+	}
+	return stat, nil
+}
+
+// Hash calculates the CID a file or directory tree would be added as,
+// without storing it on the node
+func (c *IPFSClient) Hash(path string) (string, error) {
+	var obj *IPFSObject
+	err := c.retry("add", func() (err error) {
+		obj, err = c.add(path, url.Values{"only-hash": {"true"}, "pin": {"false"}}, nil)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return obj.CID, nil
+}
+
+func (c *IPFSClient) add(path string, q url.Values, progress func(done, total int64)) (*IPFSObject, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	root := filepath.Base(abs)
+
+	var total int64
+	if err := filepath.Walk(path, func(p string, fi os.FileInfo, err error) error {
+		if err == nil && fi.Mode().IsRegular() {
+			total += fi.Size()
+		}
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		pw.CloseWithError(writeAddBody(mw, path, root, fi.IsDir()))
+	}()
+
+	req, err := http.NewRequest("POST", c.URL+"/api/v0/add?"+q.Encode(), pr)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	res, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, ipfsAPIError(res)
+	}
+
+	// the response streams an object per added file & directory, interleaved
+	// with progress updates. the root comes last
+	var (
+		added *IPFSObject
+		done  int64
+		prev  = map[string]int64{}
+		dec   = json.NewDecoder(res.Body)
+	)
+	for {
+		ev := struct {
+			Name  string
+			Hash  string
+			Bytes int64
+			Size  string
+		}{}
+		if err := dec.Decode(&ev); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("reading add response: %s", err)
+		}
+
+		if ev.Hash == "" {
+			// progress counts are per file
+			done += ev.Bytes - prev[ev.Name]
+			prev[ev.Name] = ev.Bytes
+			if progress != nil {
+				progress(done, total)
+			}
+			continue
+		}
+		if ev.Name == root {
+			added = &IPFSObject{CID: ev.Hash}
+		}
+	}
+	if added == nil {
+		return nil, fmt.Errorf("ipfs node didn't report adding %s", root)
+	}
+
+	cid, err := ParseCID(added.CID)
+	if err != nil {
+		return nil, fmt.Errorf("ipfs node returned %q: %s", added.CID, err)
+	}
+	added.CID = cid
+	return added, nil
+}
+
+// writeAddBody writes a file or directory tree as the multipart body the add
+// endpoint expects. each part is named for its path, directories are parts
+// with the application/x-directory content type
+func writeAddBody(mw *multipart.Writer, path, name string, isDir bool) error {
+	if !isDir {
+		if err := writeAddPart(mw, path, name, false); err != nil {
+			return err
+		}
+		return mw.Close()
+	}
+
+	err := filepath.Walk(path, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(path, p)
+		if err != nil {
+			return err
+		}
+		partName := filepath.ToSlash(filepath.Join(name, rel))
+		if fi.IsDir() {
+			return writeAddPart(mw, p, partName, true)
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		return writeAddPart(mw, p, partName, false)
+	})
+	if err != nil {
+		return err
+	}
+	return mw.Close()
+}
+
+func writeAddPart(mw *multipart.Writer, path, name string, isDir bool) error {
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{
+		"name":     "file",
+		"filename": url.PathEscape(name),
+	}))
+	if isDir {
+		h.Set("Content-Type", "application/x-directory")
+		_, err := mw.CreatePart(h)
+		return err
+	}
+
+	h.Set("Content-Type", "application/octet-stream")
+	w, err := mw.CreatePart(h)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// Stat reads back the size of stored content
+func (c *IPFSClient) Stat(cid string) (*IPFSObject, error) {
+	res := struct {
+		Hash           string
+		Size           uint64
+		CumulativeSize uint64
+	}{}
+	err := c.retry("files/stat", func() error {
+		return c.call("files/stat", url.Values{"arg": {"/ipfs/" + cid}}, &res)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &IPFSObject{CID: res.Hash, Size: res.Size, CumulativeSize: res.CumulativeSize}, nil
+}
+
+// NamePublish points the IPNS name of a key on the node at a CID, returning
+// the IPNS name
+func (c *IPFSClient) NamePublish(key, cid string) (string, error) {
+	res := struct {
+		Name  string
+		Value string
+	}{}
+	err := c.retry("name/publish", func() error {
+		return c.call("name/publish", url.Values{"arg": {"/ipfs/" + cid}, "key": {key}}, &res)
+	})
+	if err != nil {
+		return "", err
+	}
+	return res.Name, nil
+}
+
+// Version returns the version of the node. it makes a single attempt, so
+// callers can tell quickly whether a node is running
+func (c *IPFSClient) Version() (string, error) {
+	res := struct{ Version string }{}
+	if err := c.call("version", url.Values{}, &res); err != nil {
+		return "", err
+	}
+	return res.Version, nil
+}
+
+// call makes an API request without a body, decoding the JSON response
+func (c *IPFSClient) call(endpoint string, q url.Values, out interface{}) error {
+	res, err := c.HTTP.Post(c.URL+"/api/v0/"+endpoint+"?"+q.Encode(), "", nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return ipfsAPIError(res)
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// retry runs an API request, re-attempting it with backoff while it fails
+// for reasons c.Retry considers transient
+func (c *IPFSClient) retry(endpoint string, req func() error) (err error) {
+	if c.Retry == nil || c.Retry.Attempts < 2 {
+		return req()
+	}
+	backoff := c.Retry.Backoff
+	for attempt := 1; ; attempt++ {
+		err = req()
+		if err == nil || attempt >= c.Retry.Attempts || !c.Retry.retryableRequest(err) {
+			return err
+		}
+		log.Warnf("ipfs %s failed (attempt %d of %d): %s. retrying in %s", endpoint, attempt, c.Retry.Attempts, err, backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// ipfsAPIError reads an error response, which looks like
+// {"Message": "...", "Code": 0, "Type": "error"}
+func ipfsAPIError(res *http.Response) error {
+	data, _ := ioutil.ReadAll(res.Body)
+	apiErr := struct{ Message string }{}
+	if json.Unmarshal(data, &apiErr) == nil && apiErr.Message != "" {
+		return fmt.Errorf("ipfs api: %s", apiErr.Message)
+	}
+	return fmt.Errorf("ipfs api: %s", res.Status)
+}
+
+// logProgress returns an Add progress func that logs at most once a second
+func logProgress(name string) func(done, total int64) {
+	var last time.Time
+	return func(done, total int64) {
+		if time.Since(last) < time.Second && done < total {
+			return
+		}
+		last = time.Now()
+		pct := 100.0
+		if total > 0 {
+			pct = float64(done) / float64(total) * 100
+		}
+		log.Infof("adding %s: %d of %d bytes (%.0f%%)", name, done, total, pct)
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// FakeIPFSAPI is an http.Handler that stands in for an IPFS node's API. It
+// implements add, files/stat, name/publish & version. CIDs are CIDv1 raw
+// sha2-256 hashes of the content, which is enough to tell content apart
+type FakeIPFSAPI struct {
+	lk sync.Mutex
+	// Objects maps CIDs to stored content sizes
+	Objects map[string]uint64
+	// Names maps IPNS key names to published paths
+	Names map[string]string
+}
+
+// NewFakeIPFSAPI creates an empty fake node
+func NewFakeIPFSAPI() *FakeIPFSAPI {
+	return &FakeIPFSAPI{Objects: map[string]uint64{}, Names: map[string]string{}}
+}
+
+// ServeHTTP implements the http.Handler interface
+func (f *FakeIPFSAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+
+	switch r.URL.Path {
+	case "/api/v0/add":
+		f.add(w, r, r.URL.Query().Get("only-hash") != "true")
+	case "/api/v0/files/stat":
+		cid := strings.TrimPrefix(r.URL.Query().Get("arg"), "/ipfs/")
+		size, ok := f.Objects[cid]
+		if !ok {
+			fakeIPFSError(w, "not found")
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"Hash": cid, "Size": size, "CumulativeSize": size})
+	case "/api/v0/version":
+		json.NewEncoder(w).Encode(map[string]string{"Version": "0.9.1"})
+	case "/api/v0/name/publish":
+		key := r.URL.Query().Get("key")
+		f.Names[key] = r.URL.Query().Get("arg")
+		json.NewEncoder(w).Encode(map[string]string{"Name": "k51" + key, "Value": f.Names[key]})
+	default:
+		fakeIPFSError(w, "unknown endpoint "+r.URL.Path)
+	}
+}
+
+func (f *FakeIPFSAPI) add(w http.ResponseWriter, r *http.Request, store bool) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		fakeIPFSError(w, err.Error())
+		return
+	}
+
+	// every part is hashed into its own & all of its parent directories' CIDs
+	hashes := map[string]*sizedHash{}
+	var order []string
+	enc := json.NewEncoder(w)
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			fakeIPFSError(w, err.Error())
+			return
+		}
+		name, err := url.PathUnescape(part.FileName())
+		if err != nil {
+			fakeIPFSError(w, err.Error())
+			return
+		}
+		hashes[name] = &sizedHash{h: sha256.New()}
+		order = append(order, name)
+
+		if part.Header.Get("Content-Type") == "application/x-directory" {
+			continue
+		}
+		data, err := ioutil.ReadAll(part)
+		if err != nil {
+			fakeIPFSError(w, err.Error())
+			return
+		}
+		for p := name; p != "."; p = filepath.ToSlash(filepath.Dir(p)) {
+			if sh, ok := hashes[p]; ok {
+				sh.h.Write([]byte(name))
+				sh.h.Write(data)
+				sh.size += uint64(len(data))
+			}
+		}
+		enc.Encode(map[string]interface{}{"Name": name, "Bytes": len(data)})
+	}
+
+	// children are reported before parents, the root last
+	for i := len(order) - 1; i >= 0; i-- {
+		name := order[i]
+		cid := fakeCID(hashes[name].h.Sum(nil))
+		if store {
+			f.Objects[cid] = hashes[name].size
+		}
+		enc.Encode(map[string]string{"Name": name, "Hash": cid, "Size": fmt.Sprint(hashes[name].size)})
+	}
+}
+
+type sizedHash struct {
+	h    hash.Hash
+	size uint64
+}
+
+// fakeCID formats a sha2-256 digest as a base32 CIDv1 with the raw codec
+func fakeCID(digest []byte) string {
+	var buf []byte
+	// cid version, raw codec, sha2-256 multihash code & digest length
+	for _, v := range []uint64{1, 0x55, 0x12, uint64(len(digest))} {
+		b := make([]byte, binary.MaxVarintLen64)
+		buf = append(buf, b[:binary.PutUvarint(b, v)]...)
+	}
+	buf = append(buf, digest...)
+	return "b" + strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(buf))
+}
+
+func fakeIPFSError(w http.ResponseWriter, msg string) {
+	w.WriteHeader(http.StatusInternalServerError)
+	json.NewEncoder(w).Encode(map[string]interface{}{"Message": msg, "Code": 0, "Type": "error"})
+}
+
+// newTestIPFSClient serves api with a test server & returns a client for it
+// that retries without waiting
+func newTestIPFSClient(t *testing.T, api http.Handler) *IPFSClient {
+	t.Helper()
+	s := httptest.NewServer(api)
+	t.Cleanup(s.Close)
+	retry := *ipfsRetryPolicy
+	retry.Backoff = time.Millisecond
+	return &IPFSClient{URL: s.URL, HTTP: s.Client(), Retry: &retry}
+}
+
+// flakyIPFSAPI fails the first Failures requests to each endpoint with
+// Status before handing them to API
+type flakyIPFSAPI struct {
+	API      http.Handler
+	Failures int
+	Status   int
+
+	lk       sync.Mutex
+	requests map[string]int
+}
+
+func (f *flakyIPFSAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lk.Lock()
+	if f.requests == nil {
+		f.requests = map[string]int{}
+	}
+	f.requests[r.URL.Path]++
+	n := f.requests[r.URL.Path]
+	f.lk.Unlock()
+
+	if n <= f.Failures {
+		w.WriteHeader(f.Status)
+		return
+	}
+	f.API.ServeHTTP(w, r)
+}
+
+func (f *flakyIPFSAPI) Requests(endpoint string) int {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	return f.requests["/api/v0/"+endpoint]
+}
+
+func TestMultiaddrURL(t *testing.T) {
+	cases := []struct {
+		addr, url string
+	}{
+		{"/ip4/127.0.0.1/tcp/5001", "http://127.0.0.1:5001"},
+		{"/ip6/::1/tcp/5001", "http://[::1]:5001"},
+		{"/dns4/ipfs.example.com/tcp/443/https", "https://ipfs.example.com:443"},
+		{"/ip4/localhost/tcp/5001", ""},
+		{"/ip4/127.0.0.1/udp/5001", ""},
+		{"/unix/tmp/ipfs.sock", ""},
+	}
+	for _, c := range cases {
+		got, err := multiaddrURL(c.addr)
+		if c.url == "" {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %s", c.addr, got)
+			}
+			continue
+		}
+		if err != nil || got != c.url {
+			t.Errorf("%s: expected %s, got %s (%v)", c.addr, c.url, got, err)
+		}
+	}
+}
+
+func TestIPFSClientAdd(t *testing.T) {
+	api := NewFakeIPFSAPI()
+	client := newTestIPFSClient(t, api)
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"webapp/index.html":    "<html></html>",
+		"webapp/static/app.js": "console.log('qri')",
+	})
+	path := filepath.Join(dir, "webapp")
+
+	hashed, err := client.Hash(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(api.Objects) != 0 {
+		t.Errorf("expected hashing not to store anything, stored %v", api.Objects)
+	}
+
+	var lastDone, lastTotal int64
+	obj, err := client.Add(path, func(done, total int64) { lastDone, lastTotal = done, total })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.CID != hashed {
+		t.Errorf("expected add & hash to agree. hashed %s, added %s", hashed, obj.CID)
+	}
+	if obj.CumulativeSize != 31 {
+		t.Errorf("expected cumulative size 31, got %d", obj.CumulativeSize)
+	}
+	if lastDone != 31 || lastTotal != 31 {
+		t.Errorf("expected progress to finish at 31 of 31 bytes, got %d of %d", lastDone, lastTotal)
+	}
+	if _, ok := api.Objects[obj.CID]; !ok {
+		t.Errorf("expected %s to be stored", obj.CID)
+	}
+
+	writeFiles(t, dir, map[string]string{"webapp/index.html": "<html>changed</html>"})
+	if changed, err := client.Hash(path); err != nil || changed == hashed {
+		t.Errorf("expected changing content to change the CID, got %s (%v)", changed, err)
+	}
+}
+
+func TestIPFSClientStatNotFound(t *testing.T) {
+	api := &flakyIPFSAPI{API: NewFakeIPFSAPI()}
+	client := newTestIPFSClient(t, api)
+
+	_, err := client.Stat(fakeCID([]byte("missing")))
+	if err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("expected a not found error, got %v", err)
+	}
+	if n := api.Requests("files/stat"); n != 1 {
+		t.Errorf("expected permanent errors not to be retried, made %d requests", n)
+	}
+}
+
+func TestIPFSClientNamePublish(t *testing.T) {
+	api := NewFakeIPFSAPI()
+	client := newTestIPFSClient(t, api)
+
+	name, err := client.NamePublish("qri-webapp", "bafkreiexample")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "k51qri-webapp" {
+		t.Errorf("unexpected ipns name %s", name)
+	}
+	if api.Names["qri-webapp"] != "/ipfs/bafkreiexample" {
+		t.Errorf("expected key to point at the CID, got %q", api.Names["qri-webapp"])
+	}
+}
+
+func TestIPFSClientRetries(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"webapp/index.html": "<html></html>"})
+
+	api := &flakyIPFSAPI{API: NewFakeIPFSAPI(), Failures: 2, Status: http.StatusServiceUnavailable}
+	client := newTestIPFSClient(t, api)
+
+	obj, err := client.Add(filepath.Join(dir, "webapp"), nil)
+	if err != nil {
+		t.Fatalf("expected add to succeed on the third attempt: %s", err)
+	}
+	if _, err := client.NamePublish("qri-webapp", obj.CID); err != nil {
+		t.Fatalf("expected publishing to succeed on the third attempt: %s", err)
+	}
+	for _, endpoint := range []string{"add", "files/stat", "name/publish"} {
+		if n := api.Requests(endpoint); n != 3 {
+			t.Errorf("expected 3 %s requests, got %d", endpoint, n)
+		}
+	}
+
+	api = &flakyIPFSAPI{API: NewFakeIPFSAPI(), Failures: 3, Status: http.StatusBadGateway}
+	client = newTestIPFSClient(t, api)
+	if _, err := client.Add(filepath.Join(dir, "webapp"), nil); err == nil || !strings.Contains(err.Error(), "502") {
+		t.Errorf("expected add to give up with the last error, got %v", err)
+	}
+	if n := api.Requests("add"); n != ipfsRetryPolicy.Attempts {
+		t.Errorf("expected %d add attempts, got %d", ipfsRetryPolicy.Attempts, n)
+	}
+}
+
+func TestIPFSClientRetriesConnectionRefused(t *testing.T) {
+	s := httptest.NewServer(NewFakeIPFSAPI())
+	addr := s.URL
+	s.Close()
+
+	retry := *ipfsRetryPolicy
+	retry.Backoff = time.Millisecond
+	client := &IPFSClient{URL: addr, HTTP: http.DefaultClient, Retry: &retry}
+
+	start := time.Now()
+	_, err := client.Stat(fakeCID([]byte("qri")))
+	if err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Fatalf("expected connection refused, got %v", err)
+	}
+	// two retries, waiting 1ms then 2ms
+	if elapsed := time.Since(start); elapsed < 3*time.Millisecond {
+		t.Errorf("expected connection errors to be retried with backoff, gave up after %s", elapsed)
+	}
+}
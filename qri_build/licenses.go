@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// thirdPartyLicensesFilename is the name of the file in each archive holding
+// the licenses of compiled-in dependencies
+const thirdPartyLicensesFilename = "THIRD_PARTY_LICENSES"
+
+// LicensePolicy decides which dependency licenses may ship with qri
+type LicensePolicy struct {
+	// Allow lists SPDX identifiers of licenses compatible with qri's GPLv3.
+	// dependencies under any other detected license fail the build
+	Allow []string `json:"allow"`
+	// Unknown is what to do when no license can be detected for a module:
+	// "warn" (the default) or "error"
+	Unknown string `json:"unknown"`
+	// Overrides maps module paths to SPDX identifiers, for modules that are
+	// detected wrongly or not at all
+	Overrides map[string]string `json:"overrides"`
+}
+
+// DefaultAllowedLicenses are licenses that can be combined with GPLv3
+var DefaultAllowedLicenses = []string{
+	"Apache-2.0",
+	"BSD-2-Clause",
+	"BSD-3-Clause",
+	"CC0-1.0",
+	"GPL-3.0",
+	"ISC",
+	"LGPL-2.1",
+	"LGPL-3.0",
+	"MIT",
+	"MPL-2.0",
+	"Unlicense",
+}
+
+// UnknownLicense is reported for modules without a recognizable license
+const UnknownLicense = "unknown"
+
+// ModuleLicense is the license of one dependency module
+type ModuleLicense struct {
+	Path    string
+	Version string
+	// License is an SPDX identifier, or UnknownLicense
+	License string
+	// Files are the license & notice files found in the module root
+	Files []string
+}
+
+// Check compares module licenses against the policy, logging a warning for
+// each problem. It errors if any module violates the policy
+func (p LicensePolicy) Check(mods []ModuleLicense) error {
+	allowed := map[string]bool{}
+	for _, id := range p.Allow {
+		allowed[id] = true
+	}
+
+	var violations []string
+	for _, m := range mods {
+		switch {
+		case m.License == UnknownLicense:
+			log.Warnf("no license detected for %s %s", m.Path, m.Version)
+			if p.Unknown == "error" {
+				violations = append(violations, fmt.Sprintf("%s %s: unknown license", m.Path, m.Version))
+			}
+		case !allowed[m.License]:
+			log.Warnf("%s %s is licensed %s, which isn't allowed", m.Path, m.Version, m.License)
+			violations = append(violations, fmt.Sprintf("%s %s: %s", m.Path, m.Version, m.License))
+		}
+	}
+
+	if len(violations) > 0 {
+		return fmt.Errorf("dependency licenses violate policy:\n  %s", strings.Join(violations, "\n  "))
+	}
+	return nil
+}
+
+// CollectLicenses finds the license files of go modules listed in an SBOM,
+// reading them from the module cache of the go toolchain that built them.
+// repoPath resolves modules replaced with local directories
+func CollectLicenses(goBin, repoPath string, components []SBOMComponent, p LicensePolicy) ([]ModuleLicense, error) {
+	modCache, err := goModCache(goBin)
+	if err != nil {
+		return nil, err
+	}
+
+	var mods []ModuleLicense
+	for _, c := range components {
+		if !strings.HasPrefix(c.PURL, "pkg:golang/") {
+			continue
+		}
+
+		dir := filepath.Join(modCache, escapeModulePath(c.Name)+"@"+escapeModulePath(c.Version))
+		switch {
+		case filepath.IsAbs(c.Name):
+			dir = c.Name
+		case strings.HasPrefix(c.Name, "."):
+			dir = filepath.Join(repoPath, c.Name)
+		}
+
+		m := ModuleLicense{Path: c.Name, Version: c.Version, License: UnknownLicense}
+		if m.Files, err = licenseFiles(dir); err != nil {
+			return nil, fmt.Errorf("reading licenses of %s %s: %s", c.Name, c.Version, err)
+		}
+		for _, f := range m.Files {
+			if id := detectLicense(f); id != "" {
+				m.License = id
+				break
+			}
+		}
+		if id, ok := p.Overrides[c.Name]; ok {
+			m.License = id
+		}
+		mods = append(mods, m)
+	}
+
+	sort.Slice(mods, func(i, j int) bool { return mods[i].Path < mods[j].Path })
+	return mods, nil
+}
+
+// goModCache returns the module cache directory used by a go toolchain
+func goModCache(goBin string) (string, error) {
+	out, err := command{String: "%s env GOMODCACHE GOPATH", Tmpl: []interface{}{goBin}, Env: goEnv(environ(nil))}.SecretRunStdout()
+	if err != nil {
+		return "", err
+	}
+	lines := strings.Split(out, "\n")
+	if dir := strings.TrimSpace(lines[0]); dir != "" {
+		return dir, nil
+	}
+	// go versions before 1.15 don't report GOMODCACHE, so it's always in the
+	// first GOPATH entry
+	if len(lines) > 1 && strings.TrimSpace(lines[1]) != "" {
+		return filepath.Join(filepath.SplitList(strings.TrimSpace(lines[1]))[0], "pkg", "mod"), nil
+	}
+	return "", fmt.Errorf("couldn't locate the go module cache")
+}
+
+// escapeModulePath applies the module cache's case encoding, which replaces
+// upper case letters with "!" followed by the lower case letter
+func escapeModulePath(path string) string {
+	var b strings.Builder
+	for _, r := range path {
+		if unicode.IsUpper(r) {
+			b.WriteByte('!')
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+var licenseFileRegexp = regexp.MustCompile(`(?i)^(un)?(licen[cs]e|copying|notice)([.-].*)?$`)
+
+// licenseFiles lists license & notice files in the root of a module
+func licenseFiles(dir string) ([]string, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		log.Warnf("module source %s isn't in the module cache", dir)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, fi := range fis {
+		if !fi.IsDir() && licenseFileRegexp.MatchString(fi.Name()) {
+			files = append(files, filepath.Join(dir, fi.Name()))
+		}
+	}
+	return files, nil
+}
+
+// licenseMatchers identify licenses by phrases from their text. Order
+// matters: more specific licenses come before the ones they contain
+var licenseMatchers = []struct {
+	ID      string
+	Phrases []string
+}{
+	{"AGPL-3.0", []string{"gnu affero general public license"}},
+	{"LGPL-3.0", []string{"gnu lesser general public license", "version 3"}},
+	{"LGPL-2.1", []string{"gnu lesser general public license", "version 2.1"}},
+	{"GPL-3.0", []string{"gnu general public license", "version 3"}},
+	{"GPL-2.0", []string{"gnu general public license", "version 2"}},
+	{"MPL-2.0", []string{"mozilla public license", "2.0"}},
+	{"Apache-2.0", []string{"apache license", "version 2.0"}},
+	{"MIT", []string{"permission is hereby granted, free of charge"}},
+	{"ISC", []string{"permission to use, copy, modify, and", "distribute this software for any purpose with or without fee is hereby granted"}},
+	{"BSD-3-Clause", []string{"redistribution and use in source and binary forms", "neither the name"}},
+	{"BSD-3-Clause", []string{"redistribution and use in source and binary forms", "names of its contributors may be used"}},
+	{"BSD-2-Clause", []string{"redistribution and use in source and binary forms"}},
+	{"Unlicense", []string{"this is free and unencumbered software released into the public domain"}},
+	{"CC0-1.0", []string{"cc0 1.0 universal"}},
+}
+
+// detectLicense returns the SPDX identifier of the license in a file, or ""
+// if it isn't recognized
+func detectLicense(path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	text := strings.ToLower(strings.Join(strings.Fields(string(data)), " "))
+
+outer:
+	for _, m := range licenseMatchers {
+		for _, phrase := range m.Phrases {
+			if !strings.Contains(text, phrase) {
+				continue outer
+			}
+		}
+		return m.ID
+	}
+	return ""
+}
+
+// WriteThirdPartyLicenses writes each module's license files to w
+func WriteThirdPartyLicenses(w io.Writer, mods []ModuleLicense) error {
+	rule := strings.Repeat("=", 80)
+	if _, err := fmt.Fprintf(w, "qri includes the following third party go modules, distributed under\nthe licenses below.\n\n"); err != nil {
+		return err
+	}
+	for _, m := range mods {
+		if _, err := fmt.Fprintf(w, "%s\n%s %s\nLicense: %s\n%s\n\n", rule, m.Path, m.Version, m.License, rule); err != nil {
+			return err
+		}
+		for _, f := range m.Files {
+			data, err := ioutil.ReadFile(f)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "--- %s ---\n\n%s\n\n", filepath.Base(f), strings.TrimSpace(string(data))); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
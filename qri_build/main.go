@@ -11,13 +11,46 @@ var log = logrus.New()
 var RootCmd = &cobra.Command{
 	Use:   "qri_build",
 	Short: "CLI for building qri deliverables",
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		path, err := cmd.Flags().GetString("config")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if path != "" {
+			if cfg, err = LoadConfig(path); err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		managed, err := cmd.Flags().GetBool("managed-toolchain")
+		if err != nil {
+			log.Fatal(err)
+		}
+		if managed {
+			cfg.Toolchain.Managed = true
+		}
+	},
 }
 
 func init() {
+	RootCmd.PersistentFlags().String("config", "", "path to a qri_build JSON configuration file")
+	RootCmd.PersistentFlags().Bool("managed-toolchain", false, "build with a pinned go toolchain matching qri's go.mod, downloading it if needed")
 	RootCmd.AddCommand(
 		QriCmd,
 		DesktopCmd,
+		ElectronCmd,
 		HomebrewCmd,
+		DoctorCmd,
+		PackagesCmd,
+		RepositoryCmd,
+		SignCmd,
+		VerifyCmd,
+		KeygenCmd,
+		IPFSCmd,
+		WebappCmd,
+		InstallCmd,
+		InstallScriptCmd,
+		IndexCmd,
 	)
 }
 
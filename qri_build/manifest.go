@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// manifestFilename is the name of the manifest file in a release directory
+const manifestFilename = "manifest.json"
+
+// Manifest records the artifacts of a release. It's written alongside the
+// artifacts it lists
+type Manifest struct {
+	Version string `json:"version,omitempty"`
+	// Webapp is the IPFS path of the webapp built into qri binaries
+	Webapp    string      `json:"webapp,omitempty"`
+	Created   time.Time   `json:"created"`
+	Artifacts []*Artifact `json:"artifacts"`
+
+	path string
+	lk   sync.Mutex
+}
+
+// Artifact is a single release file
+type Artifact struct {
+	// Name is the artifact's path, relative to the manifest
+	Name   string `json:"name"`
+	Kind   string `json:"kind"`
+	Target string `json:"target,omitempty"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	// Signature is set when the artifact was codesigned
+	Signature *Signature `json:"signature,omitempty"`
+	// SBOM names the artifact's bill of materials, if it has one
+	SBOM string `json:"sbom,omitempty"`
+	// URL is where the artifact is published. set in release indexes, where
+	// artifacts aren't next to the index
+	URL string `json:"url,omitempty"`
+}
+
+// artifact kinds
+const (
+	ArtifactArchive   = "archive"
+	ArtifactPackage   = "package"
+	ArtifactInstaller = "installer"
+	ArtifactSBOM      = "sbom"
+	ArtifactScript    = "script"
+	ArtifactUpdate    = "update"
+)
+
+// releaseDirUsage describes the --dir flag of commands that read a release
+// directory
+const releaseDirUsage = "release directory containing manifest.json: . for 'qri_build qri', output for 'qri_build desktop'. required"
+
+// releaseDir reads the required --dir flag. builds write releases to
+// different directories, so there's no default that's right for all of them
+func releaseDir(cmd *cobra.Command) (string, error) {
+	dir, err := cmd.Flags().GetString("dir")
+	if err != nil {
+		return "", err
+	}
+	if dir == "" {
+		return "", fmt.Errorf("required flag: --dir <release directory containing manifest.json>")
+	}
+	return dir, nil
+}
+
+// LoadManifest reads the manifest in dir, creating an empty one if it
+// doesn't exist
+func LoadManifest(dir string) (*Manifest, error) {
+	m := &Manifest{Created: time.Now().UTC(), path: filepath.Join(dir, manifestFilename)}
+	data, err := ioutil.ReadFile(m.path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("parsing %s: %s", m.path, err)
+	}
+	return m, nil
+}
+
+// Add records the file at path, replacing any previous entry with the same
+// name
+func (m *Manifest) Add(path, kind string, target *Target, sig *Signature) (*Artifact, error) {
+	rel, err := filepath.Rel(filepath.Dir(m.path), path)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Artifact{
+		Name:      filepath.ToSlash(rel),
+		Kind:      kind,
+		Size:      size,
+		SHA256:    fmt.Sprintf("%x", h.Sum(nil)),
+		Signature: sig,
+	}
+	if target != nil {
+		a.Target = target.String()
+	}
+
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	for i, existing := range m.Artifacts {
+		if existing.Name == a.Name {
+			m.Artifacts[i] = a
+			return a, nil
+		}
+	}
+	m.Artifacts = append(m.Artifacts, a)
+	return a, nil
+}
+
+// Save writes the manifest to disk
+func (m *Manifest) Save() error {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	sort.Slice(m.Artifacts, func(i, j int) bool { return m.Artifacts[i].Name < m.Artifacts[j].Name })
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(m.path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(m.path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,677 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// PackagesCmd builds linux distribution packages of the qri binary
+var PackagesCmd = &cobra.Command{
+	Use:   "packages",
+	Short: "build .deb, .rpm and .apk packages of the qri binary",
+	Long: `
+build native linux packages of the qri command-line binary. Each package installs
+qri to /usr/bin, along with the readme & license under /usr/share/doc/qri, man
+pages, and bash, zsh & fish completions. The package version is read from the
+qri source.
+
+Completions & man pages are generated by building & running qri for the host
+platform. If they can't be generated, packages are built without them.
+
+Packages are written to the --out directory. apk packages are placed in a
+subdirectory named for their architecture, the way alpine repositories lay them out.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		repoPath, err := cmd.Flags().GetString("qri")
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		targetStrs, err := cmd.Flags().GetStringSlice("targets")
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		excludeStrs, err := cmd.Flags().GetStringSlice("exclude")
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		formats, err := cmd.Flags().GetStringSlice("formats")
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		outDir, err := cmd.Flags().GetString("out")
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		templatesDir, err := cmd.Flags().GetString("templates")
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		targets, err := ParseTargets(targetStrs)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		if targets, err = resolveTargets(targets, excludeStrs, repoPath); err != nil {
+			log.Error(err)
+			return
+		}
+
+		templates, err := LoadArchiveTemplates(templatesDir, repoPath)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		templates.Docs = generateDocs(repoPath, templates.Version, cfg.Docs)
+
+		var wg sync.WaitGroup
+		for _, target := range targets {
+			wg.Add(1)
+			go func(target Target) {
+				if err := BuildQriPackages(target, repoPath, outDir, formats, templates); err != nil {
+					log.Errorf("%s: %s", target, err)
+				}
+				wg.Done()
+			}(target)
+		}
+		wg.Wait()
+	},
+}
+
+func init() {
+	PackagesCmd.Flags().String("qri", "qri", "path to qri repository")
+	PackagesCmd.Flags().StringSlice("targets", []string{"linux/amd64"}, "linux targets to package as linux/arch[/variant]")
+	PackagesCmd.Flags().StringSlice("exclude", nil, "linux/arch[/variant] targets to skip")
+	PackagesCmd.Flags().StringSlice("formats", []string{"deb", "rpm", "apk"}, "package formats to build (deb|rpm|apk)")
+	PackagesCmd.Flags().String("out", ".", "directory to write packages to")
+	PackagesCmd.Flags().String("templates", "", "path to archive templates directory. defaults to built-in templates")
+}
+
+// PackagesConfig holds metadata for linux packages
+type PackagesConfig struct {
+	// Maintainer is the package maintainer, formatted "Name <email>"
+	Maintainer string `json:"maintainer"`
+}
+
+const (
+	qriSummary     = "Global dataset version control system built on the distributed web"
+	qriDescription = `Qri is a global dataset version control system. Use qri to save, version,
+share and sync datasets on the distributed web.`
+	qriHomepage = "https://qri.io"
+	qriLicense  = "GPL-3.0"
+)
+
+// packageFile is a file installed by a package
+type packageFile struct {
+	// Path is the absolute install path, eg. /usr/bin/qri, or for archives
+	// the path relative to the archive root
+	Path string
+	Data []byte
+	Mode os.FileMode
+}
+
+// linuxPackage describes a package independent of its format
+type linuxPackage struct {
+	Name        string
+	Version     string
+	Summary     string
+	Description string
+	Maintainer  string
+	Homepage    string
+	License     string
+	Target      Target
+	BuildTime   time.Time
+	Files       []packageFile
+}
+
+// BuildQriPackages builds the qri binary for a linux target & packages it in
+// each of the given formats. The packaged readme is rendered from templates
+func BuildQriPackages(target Target, qriRepoPath, outDir string, formats []string, templates *ArchiveTemplates) error {
+	if target.OS != "linux" {
+		return fmt.Errorf("packages can only be built for linux targets")
+	}
+
+	pkg, err := newQriPackage(target, qriRepoPath, templates)
+	if err != nil {
+		return err
+	}
+
+	dir, err := BuildQri(target, qriRepoPath, "")
+	if err != nil {
+		return fmt.Errorf("building qri: %s", err)
+	}
+	defer CleanupQriBuild(target)
+
+	bin, err := ioutil.ReadFile(filepath.Join(dir, target.BinName()))
+	if err != nil {
+		return err
+	}
+	licenses, err := ioutil.ReadFile(filepath.Join(dir, thirdPartyLicensesFilename))
+	if err != nil {
+		return err
+	}
+	pkg.Files = append([]packageFile{{Path: "/usr/bin/qri", Data: bin, Mode: 0755}}, pkg.Files...)
+	pkg.Files = append(pkg.Files, packageFile{Path: "/usr/share/doc/qri/" + thirdPartyLicensesFilename, Data: licenses, Mode: 0644})
+
+	for _, format := range formats {
+		formatPkg := *pkg
+		if templates.Docs != nil {
+			// completion paths differ between distributions
+			docs, err := templates.Docs.PackageFiles(format)
+			if err != nil {
+				return err
+			}
+			formatPkg.Files = append(append([]packageFile{}, pkg.Files...), docs...)
+		}
+
+		path, err := WritePackage(&formatPkg, format, outDir)
+		if err != nil {
+			return fmt.Errorf("writing %s package: %s", format, err)
+		}
+		log.Infof("built package %s", path)
+	}
+	return nil
+}
+
+// newQriPackage creates package metadata & documentation files for qri.
+// binaries are added by the caller
+func newQriPackage(target Target, qriRepoPath string, templates *ArchiveTemplates) (*linuxPackage, error) {
+	version, err := QriVersion(qriRepoPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading qri version: %s", err)
+	}
+
+	readme, err := templates.File(target, "readme.md")
+	if err != nil {
+		return nil, err
+	}
+
+	pkg := &linuxPackage{
+		Name:        binName,
+		Version:     version,
+		Summary:     qriSummary,
+		Description: qriDescription,
+		Maintainer:  cfg.Packages.Maintainer,
+		Homepage:    qriHomepage,
+		License:     qriLicense,
+		Target:      target,
+		BuildTime:   time.Now().Truncate(time.Second),
+		Files: []packageFile{
+			{Path: "/usr/share/doc/qri/README.md", Data: readme, Mode: 0644},
+		},
+	}
+
+	// qri's own LICENSE, falling back to the copy archives ship with
+	license, err := ioutil.ReadFile(filepath.Join(qriRepoPath, "LICENSE"))
+	if os.IsNotExist(err) {
+		license, err = fs.ReadFile(embeddedTemplates, "templates/qri/LICENSE")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading qri license: %s", err)
+	}
+	pkg.Files = append(pkg.Files, packageFile{Path: "/usr/share/doc/qri/copyright", Data: license, Mode: 0644})
+
+	return pkg, nil
+}
+
+// WritePackage writes a package in the given format to outDir, returning the
+// path of the written file
+func WritePackage(pkg *linuxPackage, format, outDir string) (string, error) {
+	var (
+		name  string
+		err   error
+		write func(w io.Writer, pkg *linuxPackage) error
+	)
+	switch format {
+	case "deb":
+		name, err = pkg.debFilename()
+		write = writeDeb
+	case "rpm":
+		name, err = pkg.rpmFilename()
+		write = writeRPM
+	case "apk":
+		name, err = pkg.apkFilename()
+		write = writeAPK
+	default:
+		return "", fmt.Errorf("unknown package format %q", format)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(outDir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	if err := write(f, pkg); err != nil {
+		f.Close()
+		return "", err
+	}
+	return path, f.Close()
+}
+
+// dirs returns every directory the package's files live in, parents first
+func (p *linuxPackage) dirs() []string {
+	set := map[string]bool{}
+	for _, f := range p.Files {
+		for dir := path.Dir(f.Path); dir != "/"; dir = path.Dir(dir) {
+			set[dir] = true
+		}
+	}
+	dirs := make([]string, 0, len(set))
+	for dir := range set {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// installedSize is the sum of the sizes of all files in bytes
+func (p *linuxPackage) installedSize() (size int) {
+	for _, f := range p.Files {
+		size += len(f.Data)
+	}
+	return size
+}
+
+// writeTar writes the package's directories & files to tw, with paths
+// prefixed by prefix
+func (p *linuxPackage) writeTar(tw *tar.Writer, prefix string, pax func(f packageFile) map[string]string) error {
+	for _, dir := range p.dirs() {
+		hdr := &tar.Header{
+			Typeflag: tar.TypeDir,
+			Name:     prefix + strings.TrimPrefix(dir, "/") + "/",
+			Mode:     0755,
+			ModTime:  p.BuildTime,
+			Uname:    "root",
+			Gname:    "root",
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+	}
+	for _, f := range p.Files {
+		hdr := &tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     prefix + strings.TrimPrefix(f.Path, "/"),
+			Mode:     int64(f.Mode.Perm()),
+			Size:     int64(len(f.Data)),
+			ModTime:  p.BuildTime,
+			Uname:    "root",
+			Gname:    "root",
+		}
+		if pax != nil {
+			hdr.PAXRecords = pax(f)
+			hdr.Format = tar.FormatPAX
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(f.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gzipTar creates a gzipped tar archive by calling fn with a tar writer. when
+// terminate is false the tar end-of-archive marker is left off, which is how
+// apk expects its control segment
+func gzipTar(terminate bool, fn func(tw *tar.Writer) error) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	gzw, err := gzip.NewWriterLevel(buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	tw := tar.NewWriter(gzw)
+	if err := fn(tw); err != nil {
+		return nil, err
+	}
+	if terminate {
+		err = tw.Close()
+	} else {
+		err = tw.Flush()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := gzw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// splitVersion separates a version like "0.9.1-dev" into "0.9.1" & "dev"
+func splitVersion(version string) (base, pre string) {
+	version = strings.TrimPrefix(version, "v")
+	if i := strings.Index(version, "-"); i >= 0 {
+		return version[:i], version[i+1:]
+	}
+	return version, ""
+}
+
+// debian
+
+func debArch(t Target) (string, error) {
+	switch t.Arch {
+	case "amd64", "arm64", "ppc64le", "s390x":
+		return t.Arch, nil
+	case "386":
+		return "i386", nil
+	case "arm":
+		if t.Variant == "v7" {
+			return "armhf", nil
+		}
+		return "armel", nil
+	}
+	return "", fmt.Errorf("no debian architecture for %s", t)
+}
+
+// debVersion converts to a debian version. pre-releases use "~" so they
+// sort before the release
+func (p *linuxPackage) debVersion() string {
+	base, pre := splitVersion(p.Version)
+	if pre != "" {
+		return base + "~" + pre
+	}
+	return base
+}
+
+func (p *linuxPackage) debFilename() (string, error) {
+	arch, err := debArch(p.Target)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s_%s_%s.deb", p.Name, p.debVersion(), arch), nil
+}
+
+// debControl formats the package's debian control file
+func (p *linuxPackage) debControl() (string, error) {
+	arch, err := debArch(p.Target)
+	if err != nil {
+		return "", err
+	}
+	buf := &strings.Builder{}
+	fmt.Fprintf(buf, "Package: %s\n", p.Name)
+	fmt.Fprintf(buf, "Version: %s\n", p.debVersion())
+	fmt.Fprintf(buf, "Architecture: %s\n", arch)
+	fmt.Fprintf(buf, "Maintainer: %s\n", p.Maintainer)
+	fmt.Fprintf(buf, "Installed-Size: %d\n", (p.installedSize()+1023)/1024)
+	fmt.Fprintf(buf, "Section: utils\n")
+	fmt.Fprintf(buf, "Priority: optional\n")
+	fmt.Fprintf(buf, "Homepage: %s\n", p.Homepage)
+	fmt.Fprintf(buf, "Description: %s\n", p.Summary)
+	for _, line := range strings.Split(p.Description, "\n") {
+		if line == "" {
+			line = "."
+		}
+		fmt.Fprintf(buf, " %s\n", line)
+	}
+	return buf.String(), nil
+}
+
+// writeDeb writes a debian package: an ar archive of debian-binary,
+// control.tar.gz & data.tar.gz
+func writeDeb(w io.Writer, p *linuxPackage) error {
+	control, err := p.debControl()
+	if err != nil {
+		return err
+	}
+
+	md5sums := &strings.Builder{}
+	for _, f := range p.Files {
+		fmt.Fprintf(md5sums, "%x  %s\n", md5.Sum(f.Data), strings.TrimPrefix(f.Path, "/"))
+	}
+
+	controlTar, err := gzipTar(true, func(tw *tar.Writer) error {
+		files := []packageFile{
+			{Path: "control", Data: []byte(control), Mode: 0644},
+			{Path: "md5sums", Data: []byte(md5sums.String()), Mode: 0644},
+		}
+		for _, f := range files {
+			hdr := &tar.Header{
+				Name:    "./" + f.Path,
+				Mode:    int64(f.Mode),
+				Size:    int64(len(f.Data)),
+				ModTime: p.BuildTime,
+				Uname:   "root",
+				Gname:   "root",
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			if _, err := tw.Write(f.Data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	dataTar, err := gzipTar(true, func(tw *tar.Writer) error {
+		return p.writeTar(tw, "./", nil)
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(w, "!<arch>\n"); err != nil {
+		return err
+	}
+	members := []struct {
+		name string
+		data []byte
+	}{
+		{"debian-binary", []byte("2.0\n")},
+		{"control.tar.gz", controlTar},
+		{"data.tar.gz", dataTar},
+	}
+	for _, m := range members {
+		if err := writeArEntry(w, m.name, m.data, p.BuildTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeArEntry writes a single file to a unix ar archive
+func writeArEntry(w io.Writer, name string, data []byte, modTime time.Time) error {
+	hdr := fmt.Sprintf("%-16s%-12d%-6d%-6d%-8o%-10d`\n", name, modTime.Unix(), 0, 0, 0644, len(data))
+	if _, err := io.WriteString(w, hdr); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	// ar entries are aligned to two bytes
+	if len(data)%2 == 1 {
+		if _, err := io.WriteString(w, "\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readDebControl reads the control file out of a .deb package
+func readDebControl(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if !bytes.HasPrefix(data, []byte("!<arch>\n")) {
+		return "", fmt.Errorf("%s is not a debian package", path)
+	}
+
+	for off := 8; off+60 <= len(data); {
+		name := strings.TrimSuffix(strings.TrimSpace(string(data[off:off+16])), "/")
+		var size int
+		if _, err := fmt.Sscanf(string(data[off+48:off+58]), "%d", &size); err != nil {
+			return "", fmt.Errorf("reading %s: invalid ar header: %s", path, err)
+		}
+		off += 60
+		if off+size > len(data) {
+			return "", fmt.Errorf("reading %s: truncated ar entry %s", path, name)
+		}
+		member := data[off : off+size]
+		off += size + size%2
+
+		if name != "control.tar.gz" {
+			continue
+		}
+		gzr, err := gzip.NewReader(bytes.NewReader(member))
+		if err != nil {
+			return "", err
+		}
+		tr := tar.NewReader(gzr)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return "", err
+			}
+			if strings.TrimPrefix(hdr.Name, "./") == "control" {
+				control, err := ioutil.ReadAll(tr)
+				return string(control), err
+			}
+		}
+	}
+	return "", fmt.Errorf("%s has no control file", path)
+}
+
+// alpine
+
+func apkArch(t Target) (string, error) {
+	switch t.Arch {
+	case "amd64":
+		return "x86_64", nil
+	case "386":
+		return "x86", nil
+	case "arm64":
+		return "aarch64", nil
+	case "ppc64le", "s390x":
+		return t.Arch, nil
+	case "arm":
+		if t.Variant == "v7" {
+			return "armv7", nil
+		}
+		return "armhf", nil
+	}
+	return "", fmt.Errorf("no alpine architecture for %s", t)
+}
+
+// apkVersion converts to an alpine version, which only allows a fixed set of
+// pre-release suffixes & requires a package release number
+func (p *linuxPackage) apkVersion() string {
+	base, pre := splitVersion(p.Version)
+	switch {
+	case pre == "":
+	case strings.HasPrefix(pre, "alpha"), strings.HasPrefix(pre, "beta"), strings.HasPrefix(pre, "rc"):
+		base += "_" + strings.Replace(pre, ".", "", -1)
+	default:
+		base += "_pre"
+	}
+	return base + "-r0"
+}
+
+func (p *linuxPackage) apkFilename() (string, error) {
+	arch, err := apkArch(p.Target)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(arch, fmt.Sprintf("%s-%s.apk", p.Name, p.apkVersion())), nil
+}
+
+// writeAPK writes an unsigned alpine package: a gzipped control tar segment
+// holding .PKGINFO followed by a gzipped data tar
+func writeAPK(w io.Writer, p *linuxPackage) error {
+	arch, err := apkArch(p.Target)
+	if err != nil {
+		return err
+	}
+
+	dataTar, err := gzipTar(true, func(tw *tar.Writer) error {
+		return p.writeTar(tw, "", func(f packageFile) map[string]string {
+			return map[string]string{
+				"APK-TOOLS.checksum.SHA1": fmt.Sprintf("%x", sha1.Sum(f.Data)),
+			}
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	pkginfo := &strings.Builder{}
+	fmt.Fprintf(pkginfo, "# Generated by qri_build\n")
+	fmt.Fprintf(pkginfo, "pkgname = %s\n", p.Name)
+	fmt.Fprintf(pkginfo, "pkgver = %s\n", p.apkVersion())
+	fmt.Fprintf(pkginfo, "pkgdesc = %s\n", p.Summary)
+	fmt.Fprintf(pkginfo, "url = %s\n", p.Homepage)
+	fmt.Fprintf(pkginfo, "builddate = %d\n", p.BuildTime.Unix())
+	fmt.Fprintf(pkginfo, "packager = %s\n", p.Maintainer)
+	fmt.Fprintf(pkginfo, "size = %d\n", p.installedSize())
+	fmt.Fprintf(pkginfo, "arch = %s\n", arch)
+	fmt.Fprintf(pkginfo, "origin = %s\n", p.Name)
+	fmt.Fprintf(pkginfo, "license = %s\n", p.License)
+	fmt.Fprintf(pkginfo, "datahash = %x\n", sha256.Sum256(dataTar))
+
+	controlTar, err := gzipTar(false, func(tw *tar.Writer) error {
+		hdr := &tar.Header{
+			Name:    ".PKGINFO",
+			Mode:    0644,
+			Size:    int64(pkginfo.Len()),
+			ModTime: p.BuildTime,
+			Uname:   "root",
+			Gname:   "root",
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := io.WriteString(tw, pkginfo.String())
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(controlTar); err != nil {
+		return err
+	}
+	_, err = w.Write(dataTar)
+	return err
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// executorFunc adapts a function to the Executor interface
+type executorFunc func(cmd *exec.Cmd) error
+
+func (f executorFunc) Run(cmd *exec.Cmd) error { return f(cmd) }
+
+// fakeGoBuild writes a placeholder binary to the -o path of go build
+// commands, so builds can be packaged without compiling qri
+var fakeGoBuild = executorFunc(func(cmd *exec.Cmd) error {
+	for i, arg := range cmd.Args {
+		if arg == "-o" && i+1 < len(cmd.Args) {
+			path := cmd.Args[i+1]
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(cmd.Dir, path)
+			}
+			return ioutil.WriteFile(path, []byte("qri binary"), 0755)
+		}
+	}
+	return nil
+})
+
+// debDataFiles reads the installed files out of a .deb package
+func debDataFiles(t *testing.T, path string) map[string][]byte {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for off := 8; off+60 <= len(data); {
+		name := strings.TrimSpace(string(data[off : off+16]))
+		var size int
+		if _, err := fmt.Sscanf(string(data[off+48:off+58]), "%d", &size); err != nil {
+			t.Fatal(err)
+		}
+		off += 60
+		member := data[off : off+size]
+		off += size + size%2
+		if name != "data.tar.gz" {
+			continue
+		}
+
+		gzr, err := gzip.NewReader(bytes.NewReader(member))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files := map[string][]byte{}
+		tr := tar.NewReader(gzr)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				return files
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hdr.Typeflag == tar.TypeReg {
+				if files[strings.TrimPrefix(hdr.Name, ".")], err = ioutil.ReadAll(tr); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+	t.Fatalf("%s has no data.tar.gz", path)
+	return nil
+}
+
+func TestBuildQriPackages(t *testing.T) {
+	repo := fakeQriRepo(t)
+	fake := &RecordingExecutor{Responses: fakeGoResponses(t, t.TempDir()), Fallback: fakeGoBuild}
+	delete(fake.Responses, "go build")
+	useExecutor(t, fake)
+	chdir(t, t.TempDir())
+
+	templates, err := LoadArchiveTemplates("", repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := Target{OS: "linux", Arch: "amd64"}
+	if err := BuildQriPackages(target, repo, "packages", []string{"deb"}, templates); err != nil {
+		t.Fatal(err)
+	}
+
+	debs, err := filepath.Glob(filepath.Join("packages", "*.deb"))
+	if err != nil || len(debs) != 1 {
+		t.Fatalf("expected a single deb, got %v (%v)", debs, err)
+	}
+	files := debDataFiles(t, debs[0])
+
+	if string(files["/usr/bin/qri"]) != "qri binary" {
+		t.Errorf("expected the built binary to be packaged, got %q", files["/usr/bin/qri"])
+	}
+	// the fake repo has no LICENSE, the built in one is packaged instead
+	license, err := fs.ReadFile(embeddedTemplates, "templates/qri/LICENSE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(files["/usr/share/doc/qri/copyright"], license) {
+		t.Error("expected the built in LICENSE to be packaged as the copyright file")
+	}
+	if !strings.Contains(string(files["/usr/share/doc/qri/THIRD_PARTY_LICENSES"]), "github.com/blang/semver") {
+		t.Errorf("expected third party licenses to be packaged, got files %v", fileNames(files))
+	}
+}
+
+func TestNewQriPackageLicense(t *testing.T) {
+	repo := fakeQriRepo(t)
+	writeFiles(t, repo, map[string]string{"LICENSE": "qri repo license"})
+	templates, err := LoadArchiveTemplates("", repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := newQriPackage(Target{OS: "linux", Arch: "amd64"}, repo, templates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range pkg.Files {
+		if f.Path == "/usr/share/doc/qri/copyright" {
+			if string(f.Data) != "qri repo license" {
+				t.Errorf("expected the qri repo's LICENSE to win, got %q", f.Data)
+			}
+			return
+		}
+	}
+	t.Error("expected a copyright file")
+}
+
+func fileNames(files map[string][]byte) (names []string) {
+	for name := range files {
+		names = append(names, name)
+	}
+	return names
+}
+
+// gzipStreams splits concatenated gzip streams, as apk packages are built,
+// returning each stream's compressed bytes
+func gzipStreams(t *testing.T, data []byte) (streams [][]byte) {
+	t.Helper()
+	r := bytes.NewReader(data)
+	for r.Len() > 0 {
+		start := len(data) - r.Len()
+		gzr, err := gzip.NewReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gzr.Multistream(false)
+		if _, err := io.Copy(ioutil.Discard, gzr); err != nil {
+			t.Fatal(err)
+		}
+		streams = append(streams, data[start:len(data)-r.Len()])
+	}
+	return streams
+}
+
+// gunzip decompresses a single gzip stream
+func gunzip(t *testing.T, stream []byte) []byte {
+	t.Helper()
+	gzr, err := gzip.NewReader(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(gzr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestWriteAPK(t *testing.T) {
+	p := testLinuxPackage("0.10.0-beta.1")
+	name, err := p.apkFilename()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != filepath.Join("x86_64", "qri-0.10.0_beta1-r0.apk") {
+		t.Errorf("unexpected apk filename %s", name)
+	}
+	buf := &bytes.Buffer{}
+	if err := writeAPK(buf, p); err != nil {
+		t.Fatal(err)
+	}
+
+	// packages are unsigned, so there's no signature stream ahead of control
+	streams := gzipStreams(t, buf.Bytes())
+	if len(streams) != 2 {
+		t.Fatalf("expected control & data gzip streams, got %d streams", len(streams))
+	}
+	control, data := streams[0], streams[1]
+
+	tr := tar.NewReader(bytes.NewReader(gunzip(t, control)))
+	hdr, err := tr.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hdr.Name != ".PKGINFO" {
+		t.Fatalf("expected .PKGINFO to open the control segment, got %s", hdr.Name)
+	}
+	pkginfo, err := ioutil.ReadAll(tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := map[string]string{}
+	for _, line := range strings.Split(string(pkginfo), "\n") {
+		if kv := strings.SplitN(line, " = ", 2); len(kv) == 2 {
+			fields[kv[0]] = kv[1]
+		}
+	}
+	for key, expect := range map[string]string{
+		"pkgname":   "qri",
+		"pkgver":    "0.10.0_beta1-r0",
+		"pkgdesc":   p.Summary,
+		"url":       "https://qri.io",
+		"builddate": fmt.Sprint(p.BuildTime.Unix()),
+		"size":      "22",
+		"arch":      "x86_64",
+		"license":   "GPL-3.0",
+		"datahash":  fmt.Sprintf("%x", sha256.Sum256(data)),
+	} {
+		if fields[key] != expect {
+			t.Errorf(".PKGINFO %s: expected %q, got %q", key, expect, fields[key])
+		}
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		t.Errorf("expected .PKGINFO to be the only control file, got %v", err)
+	}
+
+	tr = tar.NewReader(bytes.NewReader(gunzip(t, data)))
+	files := map[string]*tar.Header{}
+	contents := map[string][]byte{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		files[hdr.Name] = hdr
+		if contents[hdr.Name], err = ioutil.ReadAll(tr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range p.Files {
+		name := strings.TrimPrefix(f.Path, "/")
+		hdr, ok := files[name]
+		if !ok {
+			t.Errorf("expected %s in the data segment, got %v", name, fileNames(contents))
+			continue
+		}
+		if os.FileMode(hdr.Mode) != f.Mode {
+			t.Errorf("%s: expected mode %o, got %o", name, f.Mode, hdr.Mode)
+		}
+		if !bytes.Equal(contents[name], f.Data) {
+			t.Errorf("%s: content mismatch", name)
+		}
+		if expect := fmt.Sprintf("%x", sha1.Sum(f.Data)); hdr.PAXRecords["APK-TOOLS.checksum.SHA1"] != expect {
+			t.Errorf("%s: expected sha1 checksum %s, got %q", name, expect, hdr.PAXRecords["APK-TOOLS.checksum.SHA1"])
+		}
+	}
+	if hdr, ok := files["usr/bin/"]; !ok || hdr.Typeflag != tar.TypeDir {
+		t.Errorf("expected parent directories in the data segment")
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// PinningTokenEnvVar holds the pinning service access token, so it needn't
+// be written to a config file
+const PinningTokenEnvVar = "QRI_BUILD_PINNING_TOKEN"
+
+// PinningConfig configures a remote pinning service that implements the IPFS
+// pinning service API: https://ipfs.github.io/pinning-services-api-spec
+type PinningConfig struct {
+	// Endpoint is the API base URL, eg. https://api.pinata.cloud/psa
+	Endpoint string `json:"endpoint"`
+	// Token is the bearer access token. falls back to PinningTokenEnvVar
+	Token string `json:"token"`
+	// Timeout is how long to wait for a pin to complete, as a duration
+	// string. defaults to 30m
+	Timeout string `json:"timeout"`
+}
+
+// pinStatus is the pinning service API's PinStatus object
+type pinStatus struct {
+	RequestID string `json:"requestid"`
+	Status    string `json:"status"`
+	Pin       struct {
+		CID  string `json:"cid"`
+		Name string `json:"name"`
+	} `json:"pin"`
+}
+
+// pinPollInterval is the longest wait between pin status checks
+const pinPollInterval = 30 * time.Second
+
+// PinRemote asks a pinning service to pin cid, waiting until the service
+// reports the pin as complete
+func PinRemote(c PinningConfig, cid, name string) error {
+	if c.Endpoint == "" {
+		return fmt.Errorf("no pinning service endpoint configured")
+	}
+	if c.Token == "" {
+		c.Token = os.Getenv(PinningTokenEnvVar)
+	}
+	if c.Token == "" {
+		return fmt.Errorf("no pinning service token. set ipfs.pinning.token or %s", PinningTokenEnvVar)
+	}
+	timeout := 30 * time.Minute
+	if c.Timeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(c.Timeout); err != nil {
+			return fmt.Errorf("invalid pinning timeout: %s", err)
+		}
+	}
+
+	body, err := json.Marshal(map[string]string{"cid": cid, "name": name})
+	if err != nil {
+		return err
+	}
+	status := &pinStatus{}
+	if err := pinningRequest(c, "POST", "/pins", body, status); err != nil {
+		return err
+	}
+	log.Infof("pin request %s for %s is %s", status.RequestID, cid, status.Status)
+
+	deadline := time.Now().Add(timeout)
+	wait := time.Second
+	for {
+		switch status.Status {
+		case "pinned":
+			return nil
+		case "failed":
+			return fmt.Errorf("pinning service failed to pin %s", cid)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %s waiting for pin to complete. last status: %s", timeout, status.Status)
+		}
+
+		time.Sleep(wait)
+		if wait *= 2; wait > pinPollInterval {
+			wait = pinPollInterval
+		}
+		if err := pinningRequest(c, "GET", "/pins/"+status.RequestID, nil, status); err != nil {
+			return err
+		}
+		log.Debugf("pin request %s is %s", status.RequestID, status.Status)
+	}
+}
+
+// pinningRequest makes an authenticated pinning service API request, decoding
+// the JSON response into res
+func pinningRequest(c PinningConfig, method, path string, body []byte, res interface{}) error {
+	url := strings.TrimSuffix(c.Endpoint, "/") + path
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		// errors are returned as {"error": {"reason": "...", "details": "..."}}
+		apiErr := struct {
+			Error struct {
+				Reason  string `json:"reason"`
+				Details string `json:"details"`
+			} `json:"error"`
+		}{}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error.Reason != "" {
+			return fmt.Errorf("%s %s: %s %s", method, url, apiErr.Error.Reason, apiErr.Error.Details)
+		}
+		return fmt.Errorf("%s %s: %s", method, url, resp.Status)
+	}
+	return json.Unmarshal(data, res)
+}
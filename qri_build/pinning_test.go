@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakePinningService is an http.Handler implementing the pinning service
+// API's POST /pins & GET /pins/{requestid}. Requests are reported "queued"
+// until they've been polled Polls times, then Result
+type fakePinningService struct {
+	Token  string
+	Polls  int
+	Result string
+
+	lk     sync.Mutex
+	pins   []pinStatus
+	polled map[string]int
+}
+
+// Pins returns every pin request received
+func (f *fakePinningService) Pins() []pinStatus {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	return append([]pinStatus(nil), f.pins...)
+}
+
+// ServeHTTP implements the http.Handler interface
+func (f *fakePinningService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	if r.Header.Get("Authorization") != "Bearer "+f.Token {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]string{"reason": "UNAUTHORIZED", "details": "bad token"}})
+		return
+	}
+	if f.polled == nil {
+		f.polled = map[string]int{}
+	}
+
+	switch {
+	case r.Method == "POST" && r.URL.Path == "/pins":
+		status := pinStatus{}
+		if err := json.NewDecoder(r.Body).Decode(&status.Pin); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		status.RequestID = "req" + status.Pin.CID
+		f.pins = append(f.pins, status)
+		json.NewEncoder(w).Encode(f.status(status))
+	case r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/pins/"):
+		id := strings.TrimPrefix(r.URL.Path, "/pins/")
+		for _, status := range f.pins {
+			if status.RequestID == id {
+				f.polled[id]++
+				json.NewEncoder(w).Encode(f.status(status))
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakePinningService) status(s pinStatus) pinStatus {
+	s.Status = "queued"
+	if f.polled[s.RequestID] >= f.Polls {
+		s.Status = f.Result
+	}
+	return s
+}
+
+// servePinning serves a pinning service, returning a config for it
+func servePinning(t *testing.T, svc *fakePinningService) PinningConfig {
+	t.Helper()
+	s := httptest.NewServer(svc)
+	t.Cleanup(s.Close)
+	return PinningConfig{Endpoint: s.URL + "/", Token: svc.Token}
+}
+
+func TestPinRemote(t *testing.T) {
+	cid := "bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi"
+	cases := []struct {
+		description string
+		svc         *fakePinningService
+		timeout     string
+		err         string
+	}{
+		{"pinned immediately", &fakePinningService{Result: "pinned"}, "", ""},
+		{"queued then pinned", &fakePinningService{Polls: 1, Result: "pinned"}, "", ""},
+		{"failed", &fakePinningService{Polls: 1, Result: "failed"}, "", "failed to pin"},
+		{"timeout", &fakePinningService{Polls: 100, Result: "pinned"}, "1ms", "timed out"},
+	}
+	for _, c := range cases {
+		c.svc.Token = "secret"
+		config := servePinning(t, c.svc)
+		config.Timeout = c.timeout
+
+		err := PinRemote(config, cid, "qri 0.9.1")
+		if c.err == "" {
+			if err != nil {
+				t.Errorf("%s: %s", c.description, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: expected error containing %q, got %v", c.description, c.err, err)
+		}
+
+		pins := c.svc.Pins()
+		if len(pins) != 1 || pins[0].Pin.CID != cid || pins[0].Pin.Name != "qri 0.9.1" {
+			t.Errorf("%s: expected a single pin request for %s, got %+v", c.description, cid, pins)
+		}
+	}
+}
+
+func TestPinRemoteConfig(t *testing.T) {
+	svc := &fakePinningService{Token: "secret", Result: "pinned"}
+	config := servePinning(t, svc)
+
+	config.Token = "wrong"
+	if err := PinRemote(config, "bafkqaaa", ""); err == nil || !strings.Contains(err.Error(), "UNAUTHORIZED bad token") {
+		t.Errorf("expected the service's error to be reported, got %v", err)
+	}
+
+	config.Token = ""
+	t.Setenv(PinningTokenEnvVar, "secret")
+	if err := PinRemote(config, "bafkqaaa", ""); err != nil {
+		t.Errorf("expected the token to be read from %s: %s", PinningTokenEnvVar, err)
+	}
+
+	t.Setenv(PinningTokenEnvVar, "")
+	if err := PinRemote(config, "bafkqaaa", ""); err == nil || !strings.Contains(err.Error(), "no pinning service token") {
+		t.Errorf("expected a missing token to fail, got %v", err)
+	}
+	if err := PinRemote(PinningConfig{}, "bafkqaaa", ""); err == nil {
+		t.Error("expected a missing endpoint to fail")
+	}
+	if err := PinRemote(PinningConfig{Endpoint: config.Endpoint, Token: "secret", Timeout: "soon"}, "bafkqaaa", ""); err == nil {
+		t.Error("expected an invalid timeout to fail")
+	}
+}
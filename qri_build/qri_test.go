@@ -0,0 +1,63 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestZipCopyFileLineEndings(t *testing.T) {
+	// long enough to span several copy buffers, with CRLF pairs throughout
+	text := strings.Repeat("MIT License\r\nCopyright (c) qri, inc.\n", 4096)
+	path := filepath.Join(t.TempDir(), thirdPartyLicensesFilename)
+	if err := ioutil.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		target Target
+		text   bool
+		expect string
+	}{
+		{Target{OS: "windows", Arch: "amd64"}, true, strings.Repeat("MIT License\r\nCopyright (c) qri, inc.\r\n", 4096)},
+		{Target{OS: "windows", Arch: "amd64"}, false, text},
+		{Target{OS: "linux", Arch: "amd64"}, true, text},
+	}
+	for _, c := range cases {
+		buf := &bytes.Buffer{}
+		zw := zip.NewWriter(buf)
+		if err := zipCopyFile(zw, c.target, path, time.Now(), c.text); err != nil {
+			t.Fatal(err)
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rc, err := zr.File[0].Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != c.expect {
+			t.Errorf("%s text=%t: line endings mismatch. got %d CRs, expected %d", c.target, c.text, bytes.Count(data, []byte("\r")), strings.Count(c.expect, "\r"))
+		}
+	}
+}
+
+func TestCRLF(t *testing.T) {
+	if got := string(crlf([]byte("a\r\nb\nc\r\n\r\n"))); got != "a\r\nb\r\nc\r\n\r\n" {
+		t.Errorf("got %q", got)
+	}
+}
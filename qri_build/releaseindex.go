@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/blang/semver"
+	"github.com/spf13/cobra"
+)
+
+// IndexCmd adds a release to the static release index
+var IndexCmd = &cobra.Command{
+	Use:   "index",
+	Short: "add a release to the releases.json & latest.json index",
+	Long: `
+index adds the release in --dir to releases.json in --index-dir, then rewrites
+latest.json for the release's channel. Publish --index-dir alongside releases so
+installers & qri's update checker have a stable, machine-readable list of
+releases.
+
+Every artifact in the release's manifest.json is listed with its download URL,
+size & sha256. URLs are --base-url joined with the artifact name, defaulting to
+the github release for the manifest's version.
+
+Releases are listed newest version first. Indexing a version again replaces its
+entry. --channel defaults to "stable", or "beta" for prerelease versions. latest
+releases for channels other than stable are written to latest-<channel>.json.
+
+With --sign both files get detached .minisig signatures made with the key
+'qri_build sign' uses, so 'qri_build install --index <url>/latest.json' can
+verify them.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		dir, err := releaseDir(cmd)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		indexDir, err := cmd.Flags().GetString("index-dir")
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		channel, err := cmd.Flags().GetString("channel")
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		baseURL, err := cmd.Flags().GetString("base-url")
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		sign, err := cmd.Flags().GetBool("sign")
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		m, err := LoadManifest(dir)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		rel, err := NewRelease(m, channel, baseURL)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		var key *SigningKey
+		if sign {
+			if key, err = LoadSigningKey(cfg.Signing); err != nil {
+				log.Error(err)
+				return
+			}
+		}
+
+		if err := UpdateReleaseIndex(indexDir, rel, key); err != nil {
+			log.Errorf("updating release index: %s", err)
+			return
+		}
+		fmt.Printf("indexed %s release %s in %s\n", rel.Channel, rel.Version, indexDir)
+	},
+}
+
+func init() {
+	IndexCmd.Flags().String("dir", "", releaseDirUsage)
+	IndexCmd.Flags().String("index-dir", "index", "directory holding releases.json & latest.json")
+	IndexCmd.Flags().String("channel", "", "release channel. defaults to stable, or beta for prerelease versions")
+	IndexCmd.Flags().String("base-url", "", "URL artifacts are downloaded from. defaults to the github release for the manifest version")
+	IndexCmd.Flags().Bool("sign", false, "sign the index files with the release signing key")
+}
+
+// release index file names
+const (
+	releasesFilename = "releases.json"
+	latestFilename   = "latest.json"
+)
+
+// ReleaseIndex lists published releases, newest first
+type ReleaseIndex struct {
+	Updated  time.Time  `json:"updated"`
+	Releases []*Release `json:"releases"`
+}
+
+// Release is a published release. It reads as a Manifest, so installers
+// accept latest.json in place of a release's manifest.json
+type Release struct {
+	Version string    `json:"version"`
+	Channel string    `json:"channel"`
+	Date    time.Time `json:"date"`
+	// Webapp is the IPFS path of the webapp built into the release
+	Webapp    string      `json:"webapp,omitempty"`
+	Artifacts []*Artifact `json:"artifacts"`
+}
+
+// NewRelease describes the release in manifest m for the index. artifact URLs
+// are baseURL joined with artifact names
+func NewRelease(m *Manifest, channel, baseURL string) (*Release, error) {
+	v, err := semver.ParseTolerant(m.Version)
+	if err != nil {
+		return nil, fmt.Errorf("manifest version %q: %s", m.Version, err)
+	}
+	if channel == "" {
+		channel = "stable"
+		if len(v.Pre) > 0 {
+			channel = "beta"
+		}
+	}
+	if baseURL == "" {
+		baseURL = fmt.Sprintf("https://github.com/qri-io/qri/releases/download/v%s", v)
+	}
+	baseURL = strings.TrimSuffix(baseURL, "/")
+
+	rel := &Release{
+		Version: v.String(),
+		Channel: channel,
+		Date:    m.Created,
+		Webapp:  m.Webapp,
+	}
+	for _, a := range m.Artifacts {
+		indexed := *a
+		indexed.URL = baseURL + "/" + a.Name
+		rel.Artifacts = append(rel.Artifacts, &indexed)
+	}
+	if len(rel.Artifacts) == 0 {
+		return nil, fmt.Errorf("release %s has no artifacts", rel.Version)
+	}
+	return rel, nil
+}
+
+// LoadReleaseIndex reads releases.json from dir. a missing index is empty
+func LoadReleaseIndex(dir string) (*ReleaseIndex, error) {
+	idx := &ReleaseIndex{}
+	data, err := ioutil.ReadFile(filepath.Join(dir, releasesFilename))
+	if os.IsNotExist(err) {
+		return idx, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, idx); err != nil {
+		return nil, fmt.Errorf("parsing %s: %s", releasesFilename, err)
+	}
+	return idx, nil
+}
+
+// Add records a release, replacing any entry with the same version
+func (idx *ReleaseIndex) Add(rel *Release) {
+	for i, existing := range idx.Releases {
+		if existing.Version == rel.Version {
+			idx.Releases[i] = rel
+			idx.sort()
+			return
+		}
+	}
+	idx.Releases = append(idx.Releases, rel)
+	idx.sort()
+}
+
+func (idx *ReleaseIndex) sort() {
+	sort.SliceStable(idx.Releases, func(i, j int) bool {
+		a, aErr := semver.ParseTolerant(idx.Releases[i].Version)
+		b, bErr := semver.ParseTolerant(idx.Releases[j].Version)
+		if aErr != nil || bErr != nil {
+			return idx.Releases[i].Date.After(idx.Releases[j].Date)
+		}
+		return a.GT(b)
+	})
+}
+
+// Latest returns the newest release on a channel, or nil
+func (idx *ReleaseIndex) Latest(channel string) *Release {
+	for _, rel := range idx.Releases {
+		if rel.Channel == channel {
+			return rel
+		}
+	}
+	return nil
+}
+
+// latestFile is the name of a channel's latest release file
+func latestFile(channel string) string {
+	if channel == "stable" {
+		return latestFilename
+	}
+	return fmt.Sprintf("latest-%s.json", channel)
+}
+
+// UpdateReleaseIndex adds rel to the index in dir, rewriting releases.json &
+// the latest file for rel's channel. files are replaced atomically, so the
+// index can be written in place on a file server. key signs both files when
+// it isn't nil
+func UpdateReleaseIndex(dir string, rel *Release, key *SigningKey) error {
+	idx, err := LoadReleaseIndex(dir)
+	if err != nil {
+		return err
+	}
+	idx.Add(rel)
+	idx.Updated = time.Now().UTC()
+
+	if err := writeIndexFile(dir, releasesFilename, idx, key); err != nil {
+		return err
+	}
+	return writeIndexFile(dir, latestFile(rel.Channel), idx.Latest(rel.Channel), key)
+}
+
+func writeIndexFile(dir, name string, v interface{}, key *SigningKey) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if key != nil {
+		// write the signature first, so it never lags behind a newer file
+		sig := key.Sign(data, name)
+		if err := installFile(filepath.Join(dir, name+sigExt), sig, 0644); err != nil {
+			return err
+		}
+	}
+	return installFile(filepath.Join(dir, name), data, 0644)
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testManifest is a release manifest listing a single linux archive
+func testManifest(version string, created time.Time) *Manifest {
+	return &Manifest{
+		Version: version,
+		Created: created,
+		Webapp:  "/ipfs/bafkqaaa",
+		Artifacts: []*Artifact{
+			{Name: "qri_linux_amd64.zip", Kind: ArtifactArchive, Target: "linux/amd64", Size: 3, SHA256: "abc"},
+		},
+	}
+}
+
+func TestNewRelease(t *testing.T) {
+	created := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		version, channel, baseURL string
+		expectChannel, expectURL  string
+	}{
+		{"0.9.1", "", "", "stable", "https://github.com/qri-io/qri/releases/download/v0.9.1/qri_linux_amd64.zip"},
+		{"v0.9.1", "", "https://dl.qri.io/v0.9.1/", "stable", "https://dl.qri.io/v0.9.1/qri_linux_amd64.zip"},
+		{"0.10.0-beta.1", "", "https://dl.qri.io/beta", "beta", "https://dl.qri.io/beta/qri_linux_amd64.zip"},
+		{"0.10.0-beta.1", "nightly", "", "nightly", "https://github.com/qri-io/qri/releases/download/v0.10.0-beta.1/qri_linux_amd64.zip"},
+	}
+	for _, c := range cases {
+		m := testManifest(c.version, created)
+		rel, err := NewRelease(m, c.channel, c.baseURL)
+		if err != nil {
+			t.Errorf("%s: %s", c.version, err)
+			continue
+		}
+		if rel.Channel != c.expectChannel {
+			t.Errorf("%s: expected channel %q, got %q", c.version, c.expectChannel, rel.Channel)
+		}
+		if rel.Artifacts[0].URL != c.expectURL {
+			t.Errorf("%s: expected url %q, got %q", c.version, c.expectURL, rel.Artifacts[0].URL)
+		}
+		if m.Artifacts[0].URL != "" {
+			t.Errorf("%s: expected the manifest's artifacts to be left alone", c.version)
+		}
+		if !rel.Date.Equal(created) || rel.Webapp != m.Webapp {
+			t.Errorf("%s: expected date & webapp to be copied from the manifest", c.version)
+		}
+	}
+
+	if _, err := NewRelease(testManifest("latest", created), "", ""); err == nil {
+		t.Error("expected an invalid version to fail")
+	}
+	m := testManifest("0.9.1", created)
+	m.Artifacts = nil
+	if _, err := NewRelease(m, "", ""); err == nil {
+		t.Error("expected a release without artifacts to fail")
+	}
+}
+
+func TestReleaseIndexOrder(t *testing.T) {
+	idx := &ReleaseIndex{}
+	created := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	for _, version := range []string{"0.9.0", "0.10.0-beta.1", "0.9.10", "0.10.0", "0.9.1"} {
+		rel, err := NewRelease(testManifest(version, created), "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		idx.Add(rel)
+	}
+
+	expect := []string{"0.10.0", "0.10.0-beta.1", "0.9.10", "0.9.1", "0.9.0"}
+	if len(idx.Releases) != len(expect) {
+		t.Fatalf("expected %d releases, got %d", len(expect), len(idx.Releases))
+	}
+	for i, version := range expect {
+		if idx.Releases[i].Version != version {
+			t.Errorf("release %d: expected %s, got %s", i, version, idx.Releases[i].Version)
+		}
+	}
+
+	if rel := idx.Latest("stable"); rel == nil || rel.Version != "0.10.0" {
+		t.Errorf("expected latest stable release 0.10.0, got %+v", rel)
+	}
+	if rel := idx.Latest("beta"); rel == nil || rel.Version != "0.10.0-beta.1" {
+		t.Errorf("expected latest beta release 0.10.0-beta.1, got %+v", rel)
+	}
+	if rel := idx.Latest("nightly"); rel != nil {
+		t.Errorf("expected no nightly release, got %+v", rel)
+	}
+}
+
+func TestLatestFile(t *testing.T) {
+	for channel, expect := range map[string]string{
+		"stable": "latest.json",
+		"beta":   "latest-beta.json",
+	} {
+		if got := latestFile(channel); got != expect {
+			t.Errorf("%s: expected %s, got %s", channel, expect, got)
+		}
+	}
+}
+
+// readIndexFile unmarshals a file written by UpdateReleaseIndex
+func readIndexFile(t *testing.T, path string, v interface{}) {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpdateReleaseIndex(t *testing.T) {
+	dir := t.TempDir()
+	created := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	for _, version := range []string{"0.9.0", "0.10.0-beta.1"} {
+		rel, err := NewRelease(testManifest(version, created), "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := UpdateReleaseIndex(dir, rel, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	latest := &Release{}
+	readIndexFile(t, filepath.Join(dir, "latest.json"), latest)
+	if latest.Version != "0.9.0" || latest.Channel != "stable" {
+		t.Errorf("expected latest.json to hold stable 0.9.0, got %s %s", latest.Channel, latest.Version)
+	}
+	beta := &Release{}
+	readIndexFile(t, filepath.Join(dir, "latest-beta.json"), beta)
+	if beta.Version != "0.10.0-beta.1" || beta.Channel != "beta" {
+		t.Errorf("expected latest-beta.json to hold beta 0.10.0-beta.1, got %s %s", beta.Channel, beta.Version)
+	}
+
+	// indexing a version again replaces its entry
+	m := testManifest("0.9.0", created.Add(time.Hour))
+	m.Artifacts[0].SHA256 = "def"
+	rel, err := NewRelease(m, "", "https://dl.qri.io")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateReleaseIndex(dir, rel, nil); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := LoadReleaseIndex(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idx.Releases) != 2 {
+		t.Fatalf("expected 2 releases, got %d", len(idx.Releases))
+	}
+	if a := idx.Releases[1].Artifacts[0]; idx.Releases[1].Version != "0.9.0" || a.SHA256 != "def" || a.URL != "https://dl.qri.io/qri_linux_amd64.zip" {
+		t.Errorf("expected 0.9.0 to be replaced, got %s %+v", idx.Releases[1].Version, a)
+	}
+	readIndexFile(t, filepath.Join(dir, "latest.json"), latest)
+	if latest.Artifacts[0].SHA256 != "def" {
+		t.Errorf("expected latest.json to be rewritten")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "latest.json"+sigExt)); err == nil {
+		t.Error("expected no signatures without a key")
+	}
+}
+
+func TestUpdateReleaseIndexSigned(t *testing.T) {
+	dir := t.TempDir()
+	key, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel, err := NewRelease(testManifest("0.10.0-beta.1", time.Now()), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateReleaseIndex(dir, rel, key); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"releases.json", "latest-beta.json"} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig, err := ioutil.ReadFile(filepath.Join(dir, name+sigExt))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := key.Public().Verify(data, sig); err != nil {
+			t.Errorf("%s: expected signature to verify: %s", name, err)
+		}
+	}
+}
+
+func TestInstallFromReleaseIndex(t *testing.T) {
+	release := t.TempDir()
+	linux := Target{OS: "linux", Arch: "amd64"}
+	key := writeTestRelease(t, release, linux)
+	releaseServer := httptest.NewServer(http.FileServer(http.Dir(release)))
+	defer releaseServer.Close()
+
+	m, err := LoadManifest(release)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel, err := NewRelease(m, "", releaseServer.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := t.TempDir()
+	if err := UpdateReleaseIndex(index, rel, key); err != nil {
+		t.Fatal(err)
+	}
+	indexServer := httptest.NewServer(http.FileServer(http.Dir(index)))
+	defer indexServer.Close()
+
+	dir := t.TempDir()
+	inst := &Installer{Client: http.DefaultClient, PublicKey: key.Public()}
+	res, err := inst.Install(indexServer.URL+"/latest.json", linux, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Version != "0.9.1" {
+		t.Errorf("expected 0.9.1 to be installed, got %s", res.Version)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "qri"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != testQriBinary {
+		t.Errorf("unexpected installed binary %q", data)
+	}
+}
@@ -0,0 +1,591 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// RepositoryCmd generates static apt & yum repositories from built packages
+var RepositoryCmd = &cobra.Command{
+	Use:   "repository",
+	Short: "generate signed apt & yum repositories from built packages",
+	Long: `
+repository collects the .deb & .rpm files in the --packages directory (the --out
+directory of 'qri_build packages') into static repositories that can be hosted on
+any file server:
+
+  apt/  pool/ with packages, dists/<suite>/ with Release, InRelease & Release.gpg
+  yum/  Packages/ with packages, repodata/ with repomd.xml & repomd.xml.asc
+
+Repository metadata is signed with gpg, using the key set by --gpg-key or the
+"repository" section of the --config file. The public key is exported to qri.asc
+at the root of the output directory.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		pkgDir, err := cmd.Flags().GetString("packages")
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		outDir, err := cmd.Flags().GetString("out")
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		key, err := cmd.Flags().GetString("gpg-key")
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		if key != "" {
+			cfg.Repository.GPGKey = key
+		}
+
+		unsigned, err := cmd.Flags().GetBool("unsigned")
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		if err := BuildRepository(pkgDir, outDir, cfg.Repository, !unsigned); err != nil {
+			log.Errorf("building repository: %s", err)
+		}
+	},
+}
+
+func init() {
+	RepositoryCmd.Flags().String("packages", ".", "directory of built .deb & .rpm packages")
+	RepositoryCmd.Flags().String("out", "repository", "directory to write repositories to")
+	RepositoryCmd.Flags().String("gpg-key", "", "gpg key id to sign repository metadata with")
+	RepositoryCmd.Flags().Bool("unsigned", false, "skip signing, for local testing only")
+}
+
+// RepositoryConfig configures generated package repositories
+type RepositoryConfig struct {
+	// GPGKey is the id of the gpg key to sign metadata with
+	GPGKey string `json:"gpgKey"`
+	// GPGHome is an alternate gpg home directory holding the key
+	GPGHome string `json:"gpgHome"`
+	// Origin & Label identify the apt repository
+	Origin string `json:"origin"`
+	Label  string `json:"label"`
+	// Suite & Component name the apt distribution, eg. "stable" & "main"
+	Suite     string `json:"suite"`
+	Component string `json:"component"`
+}
+
+// BuildRepository writes apt & yum repositories for the packages in pkgDir
+// to outDir
+func BuildRepository(pkgDir, outDir string, rc RepositoryConfig, sign bool) error {
+	if sign && rc.GPGKey == "" {
+		return fmt.Errorf("a gpg key is required to sign repositories, set --gpg-key or pass --unsigned")
+	}
+
+	debs, err := filepath.Glob(filepath.Join(pkgDir, "*.deb"))
+	if err != nil {
+		return err
+	}
+	rpms, err := filepath.Glob(filepath.Join(pkgDir, "*.rpm"))
+	if err != nil {
+		return err
+	}
+	if len(debs) == 0 && len(rpms) == 0 {
+		return fmt.Errorf("no .deb or .rpm packages found in %s", pkgDir)
+	}
+
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return err
+	}
+	if len(debs) > 0 {
+		if err := buildAptRepository(debs, filepath.Join(outDir, "apt"), rc, sign); err != nil {
+			return fmt.Errorf("apt: %s", err)
+		}
+	}
+	if len(rpms) > 0 {
+		if err := buildYumRepository(rpms, filepath.Join(outDir, "yum"), rc, sign); err != nil {
+			return fmt.Errorf("yum: %s", err)
+		}
+	}
+	if sign {
+		return gpgExportKey(rc, filepath.Join(outDir, "qri.asc"))
+	}
+	return nil
+}
+
+// apt
+
+// buildAptRepository lays out debs in a pool & writes the dists metadata
+func buildAptRepository(debs []string, dir string, rc RepositoryConfig, sign bool) error {
+	packages := map[string]*bytes.Buffer{}
+	for _, deb := range debs {
+		control, err := readDebControl(deb)
+		if err != nil {
+			return err
+		}
+		fields := debControlFields(control)
+		name, arch := fields["Package"], fields["Architecture"]
+		if name == "" || arch == "" {
+			return fmt.Errorf("%s: control file is missing Package or Architecture", deb)
+		}
+
+		poolPath := path.Join("pool", rc.Component, name[:1], name, filepath.Base(deb))
+		data, err := ioutil.ReadFile(deb)
+		if err != nil {
+			return err
+		}
+		if err := writeRepoFile(filepath.Join(dir, filepath.FromSlash(poolPath)), data); err != nil {
+			return err
+		}
+
+		if packages[arch] == nil {
+			packages[arch] = &bytes.Buffer{}
+		} else {
+			packages[arch].WriteString("\n")
+		}
+		stanza := packages[arch]
+		stanza.WriteString(strings.TrimRight(control, "\n") + "\n")
+		fmt.Fprintf(stanza, "Filename: %s\n", poolPath)
+		fmt.Fprintf(stanza, "Size: %d\n", len(data))
+		fmt.Fprintf(stanza, "MD5sum: %x\n", md5.Sum(data))
+		fmt.Fprintf(stanza, "SHA1: %x\n", sha1.Sum(data))
+		fmt.Fprintf(stanza, "SHA256: %x\n", sha256.Sum256(data))
+	}
+
+	distDir := filepath.Join(dir, "dists", rc.Suite)
+	arches := make([]string, 0, len(packages))
+	for arch := range packages {
+		arches = append(arches, arch)
+	}
+	sort.Strings(arches)
+
+	// index files are listed in Release relative to the dist directory
+	var indexes []string
+	indexData := map[string][]byte{}
+	for _, arch := range arches {
+		name := path.Join(rc.Component, "binary-"+arch, "Packages")
+		gz, err := gzipBytes(packages[arch].Bytes())
+		if err != nil {
+			return err
+		}
+		indexData[name] = packages[arch].Bytes()
+		indexData[name+".gz"] = gz
+		indexes = append(indexes, name, name+".gz")
+	}
+	for _, name := range indexes {
+		if err := writeRepoFile(filepath.Join(distDir, filepath.FromSlash(name)), indexData[name]); err != nil {
+			return err
+		}
+	}
+
+	release := &bytes.Buffer{}
+	fmt.Fprintf(release, "Origin: %s\n", rc.Origin)
+	fmt.Fprintf(release, "Label: %s\n", rc.Label)
+	fmt.Fprintf(release, "Suite: %s\n", rc.Suite)
+	fmt.Fprintf(release, "Codename: %s\n", rc.Suite)
+	fmt.Fprintf(release, "Date: %s\n", time.Now().UTC().Format("Mon, 02 Jan 2006 15:04:05 UTC"))
+	fmt.Fprintf(release, "Architectures: %s\n", strings.Join(arches, " "))
+	fmt.Fprintf(release, "Components: %s\n", rc.Component)
+	fmt.Fprintf(release, "Description: %s packages\n", rc.Label)
+	sums := []struct {
+		field string
+		sum   func([]byte) string
+	}{
+		{"MD5Sum", func(b []byte) string { return fmt.Sprintf("%x", md5.Sum(b)) }},
+		{"SHA1", func(b []byte) string { return fmt.Sprintf("%x", sha1.Sum(b)) }},
+		{"SHA256", func(b []byte) string { return fmt.Sprintf("%x", sha256.Sum256(b)) }},
+	}
+	for _, s := range sums {
+		fmt.Fprintf(release, "%s:\n", s.field)
+		for _, name := range indexes {
+			fmt.Fprintf(release, " %s %d %s\n", s.sum(indexData[name]), len(indexData[name]), name)
+		}
+	}
+
+	releasePath := filepath.Join(distDir, "Release")
+	if err := writeRepoFile(releasePath, release.Bytes()); err != nil {
+		return err
+	}
+	if !sign {
+		return nil
+	}
+	if err := gpgSign(rc, "--clearsign", releasePath, filepath.Join(distDir, "InRelease")); err != nil {
+		return err
+	}
+	return gpgSign(rc, "--detach-sign", releasePath, filepath.Join(distDir, "Release.gpg"))
+}
+
+// debControlFields parses the single-line fields of a debian control file
+func debControlFields(control string) map[string]string {
+	fields := map[string]string{}
+	for _, line := range strings.Split(control, "\n") {
+		if line == "" || line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		if i := strings.Index(line, ":"); i > 0 {
+			fields[line[:i]] = strings.TrimSpace(line[i+1:])
+		}
+	}
+	return fields
+}
+
+// yum
+
+const (
+	yumCommonNS    = "http://linux.duke.edu/metadata/common"
+	yumRPMNS       = "http://linux.duke.edu/metadata/rpm"
+	yumFilelistsNS = "http://linux.duke.edu/metadata/filelists"
+	yumOtherNS     = "http://linux.duke.edu/metadata/other"
+	yumRepoNS      = "http://linux.duke.edu/metadata/repo"
+)
+
+type yumVersion struct {
+	Epoch string `xml:"epoch,attr"`
+	Ver   string `xml:"ver,attr"`
+	Rel   string `xml:"rel,attr"`
+}
+
+type yumChecksum struct {
+	Type  string `xml:"type,attr"`
+	PkgID string `xml:"pkgid,attr,omitempty"`
+	Value string `xml:",chardata"`
+}
+
+type yumEntry struct {
+	Name  string `xml:"name,attr"`
+	Flags string `xml:"flags,attr,omitempty"`
+	Epoch string `xml:"epoch,attr,omitempty"`
+	Ver   string `xml:"ver,attr,omitempty"`
+	Rel   string `xml:"rel,attr,omitempty"`
+}
+
+type yumPrimaryPackage struct {
+	Type        string      `xml:"type,attr"`
+	Name        string      `xml:"name"`
+	Arch        string      `xml:"arch"`
+	Version     yumVersion  `xml:"version"`
+	Checksum    yumChecksum `xml:"checksum"`
+	Summary     string      `xml:"summary"`
+	Description string      `xml:"description"`
+	Packager    string      `xml:"packager"`
+	URL         string      `xml:"url"`
+	Time        struct {
+		File  int64 `xml:"file,attr"`
+		Build int   `xml:"build,attr"`
+	} `xml:"time"`
+	Size struct {
+		Package   int `xml:"package,attr"`
+		Installed int `xml:"installed,attr"`
+		Archive   int `xml:"archive,attr"`
+	} `xml:"size"`
+	Location struct {
+		Href string `xml:"href,attr"`
+	} `xml:"location"`
+	Format struct {
+		License     string `xml:"rpm:license"`
+		Vendor      string `xml:"rpm:vendor"`
+		Group       string `xml:"rpm:group"`
+		BuildHost   string `xml:"rpm:buildhost"`
+		SourceRPM   string `xml:"rpm:sourcerpm"`
+		HeaderRange struct {
+			Start int `xml:"start,attr"`
+			End   int `xml:"end,attr"`
+		} `xml:"rpm:header-range"`
+		Provides []yumEntry `xml:"rpm:provides>rpm:entry"`
+		Requires []yumEntry `xml:"rpm:requires>rpm:entry,omitempty"`
+		Files    []string   `xml:"file"`
+	} `xml:"format"`
+}
+
+type yumPrimary struct {
+	XMLName  xml.Name            `xml:"metadata"`
+	XMLNS    string              `xml:"xmlns,attr"`
+	RPMNS    string              `xml:"xmlns:rpm,attr"`
+	Count    int                 `xml:"packages,attr"`
+	Packages []yumPrimaryPackage `xml:"package"`
+}
+
+type yumFilelistsPackage struct {
+	PkgID   string     `xml:"pkgid,attr"`
+	Name    string     `xml:"name,attr"`
+	Arch    string     `xml:"arch,attr"`
+	Version yumVersion `xml:"version"`
+	Files   []string   `xml:"file"`
+}
+
+type yumFilelists struct {
+	XMLName  xml.Name              `xml:"filelists"`
+	XMLNS    string                `xml:"xmlns,attr"`
+	Count    int                   `xml:"packages,attr"`
+	Packages []yumFilelistsPackage `xml:"package"`
+}
+
+type yumOtherPackage struct {
+	PkgID   string     `xml:"pkgid,attr"`
+	Name    string     `xml:"name,attr"`
+	Arch    string     `xml:"arch,attr"`
+	Version yumVersion `xml:"version"`
+}
+
+type yumOther struct {
+	XMLName  xml.Name          `xml:"otherdata"`
+	XMLNS    string            `xml:"xmlns,attr"`
+	Count    int               `xml:"packages,attr"`
+	Packages []yumOtherPackage `xml:"package"`
+}
+
+type yumRepoData struct {
+	Type         string      `xml:"type,attr"`
+	Checksum     yumChecksum `xml:"checksum"`
+	OpenChecksum yumChecksum `xml:"open-checksum"`
+	Location     struct {
+		Href string `xml:"href,attr"`
+	} `xml:"location"`
+	Timestamp int64 `xml:"timestamp"`
+	Size      int   `xml:"size"`
+	OpenSize  int   `xml:"open-size"`
+}
+
+type yumRepomd struct {
+	XMLName  xml.Name      `xml:"repomd"`
+	XMLNS    string        `xml:"xmlns,attr"`
+	RPMNS    string        `xml:"xmlns:rpm,attr"`
+	Revision int64         `xml:"revision"`
+	Data     []yumRepoData `xml:"data"`
+}
+
+// rpmSenseFlags converts rpm dependency sense flags to yum's notation
+func rpmSenseFlags(flags int) string {
+	switch flags & 0x0f {
+	case 2:
+		return "LT"
+	case 4:
+		return "GT"
+	case 8:
+		return "EQ"
+	case 10:
+		return "LE"
+	case 12:
+		return "GE"
+	}
+	return ""
+}
+
+// yumEntries pairs up the name, flags & version tags of rpm dependencies
+func yumEntries(info *rpmInfo, nameTag, flagsTag, versionTag int32) (entries []yumEntry) {
+	names := info.Strings(nameTag)
+	versions := info.Strings(versionTag)
+	flags, _ := info.Tags[flagsTag].([]int32)
+	for i, name := range names {
+		// rpmlib() requirements are satisfied by rpm itself
+		if strings.HasPrefix(name, "rpmlib(") {
+			continue
+		}
+		e := yumEntry{Name: name}
+		if i < len(flags) && i < len(versions) && versions[i] != "" {
+			e.Flags = rpmSenseFlags(int(flags[i]))
+			e.Epoch = "0"
+			e.Ver, e.Rel = versions[i], ""
+			if j := strings.LastIndex(versions[i], "-"); j > 0 {
+				e.Ver, e.Rel = versions[i][:j], versions[i][j+1:]
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// buildYumRepository copies rpms into dir & writes repodata
+func buildYumRepository(rpms []string, dir string, rc RepositoryConfig, sign bool) error {
+	primary := yumPrimary{XMLNS: yumCommonNS, RPMNS: yumRPMNS}
+	filelists := yumFilelists{XMLNS: yumFilelistsNS}
+	other := yumOther{XMLNS: yumOtherNS}
+
+	for _, rpm := range rpms {
+		info, err := readRPM(rpm)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(rpm)
+		if err != nil {
+			return err
+		}
+		fi, err := os.Stat(rpm)
+		if err != nil {
+			return err
+		}
+
+		href := path.Join("Packages", filepath.Base(rpm))
+		if err := writeRepoFile(filepath.Join(dir, filepath.FromSlash(href)), data); err != nil {
+			return err
+		}
+
+		pkgID := fmt.Sprintf("%x", sha256.Sum256(data))
+		version := yumVersion{Epoch: "0", Ver: info.String(rpmTagVersion), Rel: info.String(rpmTagRelease)}
+		dirnames := info.Strings(rpmTagDirNames)
+		dirIndexes, _ := info.Tags[rpmTagDirIndexes].([]int32)
+		var files []string
+		for i, base := range info.Strings(rpmTagBaseNames) {
+			if i < len(dirIndexes) && int(dirIndexes[i]) < len(dirnames) {
+				files = append(files, dirnames[dirIndexes[i]]+base)
+			}
+		}
+
+		pkg := yumPrimaryPackage{
+			Type:        "rpm",
+			Name:        info.String(rpmTagName),
+			Arch:        info.String(rpmTagArch),
+			Version:     version,
+			Checksum:    yumChecksum{Type: "sha256", PkgID: "YES", Value: pkgID},
+			Summary:     info.String(rpmTagSummary),
+			Description: info.String(rpmTagDescription),
+			Packager:    info.String(rpmTagPackager),
+			URL:         info.String(rpmTagURL),
+		}
+		pkg.Time.File = fi.ModTime().Unix()
+		pkg.Time.Build = info.Int(rpmTagBuildTime)
+		pkg.Size.Package = len(data)
+		pkg.Size.Installed = info.Int(rpmTagSize)
+		pkg.Size.Archive = rpmInt(info.Signature[rpmSigTagPayloadSize])
+		pkg.Location.Href = href
+		pkg.Format.License = info.String(rpmTagLicense)
+		pkg.Format.Vendor = info.String(rpmTagVendor)
+		pkg.Format.Group = info.String(rpmTagGroup)
+		pkg.Format.BuildHost = info.String(rpmTagBuildHost)
+		pkg.Format.HeaderRange.Start = info.HeaderStart
+		pkg.Format.HeaderRange.End = info.HeaderEnd
+		pkg.Format.Provides = yumEntries(info, rpmTagProvideName, rpmTagProvideFlags, rpmTagProvideVersion)
+		pkg.Format.Requires = yumEntries(info, rpmTagRequireName, rpmTagRequireFlags, rpmTagRequireVersion)
+		pkg.Format.Files = files
+
+		primary.Packages = append(primary.Packages, pkg)
+		filelists.Packages = append(filelists.Packages, yumFilelistsPackage{PkgID: pkgID, Name: pkg.Name, Arch: pkg.Arch, Version: version, Files: files})
+		other.Packages = append(other.Packages, yumOtherPackage{PkgID: pkgID, Name: pkg.Name, Arch: pkg.Arch, Version: version})
+	}
+	primary.Count = len(primary.Packages)
+	filelists.Count = len(filelists.Packages)
+	other.Count = len(other.Packages)
+
+	now := time.Now().Unix()
+	repomd := yumRepomd{XMLNS: yumRepoNS, RPMNS: yumRPMNS, Revision: now}
+	docs := []struct {
+		typ string
+		doc interface{}
+	}{
+		{"primary", primary},
+		{"filelists", filelists},
+		{"other", other},
+	}
+	for _, d := range docs {
+		raw, err := marshalXML(d.doc)
+		if err != nil {
+			return err
+		}
+		gz, err := gzipBytes(raw)
+		if err != nil {
+			return err
+		}
+		href := path.Join("repodata", d.typ+".xml.gz")
+		if err := writeRepoFile(filepath.Join(dir, filepath.FromSlash(href)), gz); err != nil {
+			return err
+		}
+
+		data := yumRepoData{
+			Type:         d.typ,
+			Checksum:     yumChecksum{Type: "sha256", Value: fmt.Sprintf("%x", sha256.Sum256(gz))},
+			OpenChecksum: yumChecksum{Type: "sha256", Value: fmt.Sprintf("%x", sha256.Sum256(raw))},
+			Timestamp:    now,
+			Size:         len(gz),
+			OpenSize:     len(raw),
+		}
+		data.Location.Href = href
+		repomd.Data = append(repomd.Data, data)
+	}
+
+	raw, err := marshalXML(repomd)
+	if err != nil {
+		return err
+	}
+	repomdPath := filepath.Join(dir, "repodata", "repomd.xml")
+	if err := writeRepoFile(repomdPath, raw); err != nil {
+		return err
+	}
+	if !sign {
+		return nil
+	}
+	return gpgSign(rc, "--detach-sign", repomdPath, repomdPath+".asc")
+}
+
+func marshalXML(v interface{}) ([]byte, error) {
+	data, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(data, '\n')...), nil
+}
+
+func gzipBytes(data []byte) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	gzw := gzip.NewWriter(buf)
+	if _, err := gzw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := gzw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeRepoFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// gpg
+
+// gpgArgs returns arguments common to all gpg invocations
+func gpgArgs(rc RepositoryConfig) []string {
+	args := []string{"--batch", "--yes"}
+	if rc.GPGHome != "" {
+		args = append(args, "--homedir", rc.GPGHome)
+	}
+	return args
+}
+
+// gpgSign signs the file at src with the configured key, writing an armored
+// signature to dst. mode is --clearsign or --detach-sign
+func gpgSign(rc RepositoryConfig, mode, src, dst string) error {
+	args := append(gpgArgs(rc), "--local-user", rc.GPGKey, "--armor", "--digest-algo", "SHA256", mode, "--output", dst, src)
+	return command{String: "gpg", Args: args}.Run()
+}
+
+// gpgExportKey writes the armored public key to dst so users can add the
+// repository's key to their keyring
+func gpgExportKey(rc RepositoryConfig, dst string) error {
+	args := append(gpgArgs(rc), "--armor", "--export", rc.GPGKey)
+	key, err := command{String: "gpg", Args: args}.SecretRunStdout()
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(key) == "" {
+		return fmt.Errorf("gpg key %q not found", rc.GPGKey)
+	}
+	return writeRepoFile(dst, []byte(key))
+}
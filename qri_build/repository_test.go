@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestPackages writes a deb & rpm of a small qri package to dir
+func writeTestPackages(t *testing.T, dir string) (deb, rpm string) {
+	t.Helper()
+	pkg := &linuxPackage{
+		Name:        binName,
+		Version:     "0.9.1",
+		Summary:     qriSummary,
+		Description: qriDescription,
+		Maintainer:  "Qri <hello@qri.io>",
+		Homepage:    qriHomepage,
+		License:     qriLicense,
+		Target:      Target{OS: "linux", Arch: "amd64"},
+		BuildTime:   time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		Files: []packageFile{
+			{Path: "/usr/bin/qri", Data: []byte("#!/bin/sh\necho qri\n"), Mode: 0755},
+			{Path: "/usr/share/doc/qri/copyright", Data: []byte("GPL-3.0"), Mode: 0644},
+		},
+	}
+	var err error
+	if deb, err = WritePackage(pkg, "deb", dir); err != nil {
+		t.Fatal(err)
+	}
+	if rpm, err = WritePackage(pkg, "rpm", dir); err != nil {
+		t.Fatal(err)
+	}
+	return deb, rpm
+}
+
+// serveRepository serves a generated repository directory over http,
+// returning the server's base URL
+func serveRepository(t *testing.T, dir string) string {
+	t.Helper()
+	s := httptest.NewServer(http.FileServer(http.Dir(dir)))
+	t.Cleanup(s.Close)
+	return s.URL
+}
+
+// fetch GETs url, failing the test on anything but a 200 response
+func fetch(t *testing.T, url string) []byte {
+	t.Helper()
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: %s", url, res.Status)
+	}
+	return data
+}
+
+func TestBuildRepository(t *testing.T) {
+	pkgDir, out := t.TempDir(), t.TempDir()
+	deb, rpm := writeTestPackages(t, pkgDir)
+	rc := DefaultConfig().Repository
+
+	if err := BuildRepository(pkgDir, out, rc, true); err == nil {
+		t.Error("expected signing without a gpg key to fail")
+	}
+	if err := BuildRepository(pkgDir, out, rc, false); err != nil {
+		t.Fatal(err)
+	}
+
+	url := serveRepository(t, out)
+	checkAptRepository(t, url+"/apt", rc, deb)
+	checkYumRepository(t, url+"/yum", rpm)
+}
+
+func TestBuildRepositoryNoPackages(t *testing.T) {
+	if err := BuildRepository(t.TempDir(), t.TempDir(), DefaultConfig().Repository, false); err == nil {
+		t.Error("expected an empty package directory to fail")
+	}
+}
+
+func TestBuildRepositorySigned(t *testing.T) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg isn't installed")
+	}
+	home, err := ioutil.TempDir("", "qri_build_gpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// gpg-agent sockets live in the home directory, keep the path short
+	defer os.RemoveAll(home)
+	defer exec.Command("gpgconf", "--homedir", home, "--kill", "gpg-agent").Run()
+
+	rc := DefaultConfig().Repository
+	rc.GPGHome = home
+	rc.GPGKey = "repository-test@qri.io"
+	gen := exec.Command("gpg", "--homedir", home, "--batch", "--passphrase", "", "--quick-gen-key", rc.GPGKey, "default", "sign", "never")
+	if out, err := gen.CombinedOutput(); err != nil {
+		t.Skipf("generating a test gpg key: %s\n%s", err, out)
+	}
+
+	pkgDir, out := t.TempDir(), t.TempDir()
+	deb, rpm := writeTestPackages(t, pkgDir)
+	if err := BuildRepository(pkgDir, out, rc, true); err != nil {
+		t.Fatal(err)
+	}
+	url := serveRepository(t, out)
+	checkAptRepository(t, url+"/apt", rc, deb)
+	checkYumRepository(t, url+"/yum", rpm)
+
+	// verify signatures over what a client fetches, not what's on disk
+	fetched := t.TempDir()
+	dist := "/apt/dists/" + rc.Suite
+	for _, path := range []string{dist + "/InRelease", dist + "/Release", dist + "/Release.gpg", "/yum/repodata/repomd.xml", "/yum/repodata/repomd.xml.asc", "/qri.asc"} {
+		writeFiles(t, fetched, map[string]string{path: string(fetch(t, url+path))})
+	}
+	local := func(path string) string { return filepath.Join(fetched, filepath.FromSlash(path)) }
+	verify := [][]string{
+		{local(dist + "/InRelease")},
+		{local(dist + "/Release.gpg"), local(dist + "/Release")},
+		{local("/yum/repodata/repomd.xml.asc"), local("/yum/repodata/repomd.xml")},
+	}
+	for _, files := range verify {
+		args := append([]string{"--homedir", home, "--batch", "--verify"}, files...)
+		if out, err := exec.Command("gpg", args...).CombinedOutput(); err != nil {
+			t.Errorf("verifying %s: %s\n%s", filepath.Base(files[0]), err, out)
+		}
+	}
+
+	// InRelease must carry the same index checksums as Release
+	signed, err := exec.Command("gpg", "--homedir", home, "--batch", "--quiet", "--decrypt", local(dist+"/InRelease")).Output()
+	if err != nil {
+		t.Fatalf("reading InRelease: %s", err)
+	}
+	if release, _ := ioutil.ReadFile(local(dist + "/Release")); string(signed) != string(release) {
+		t.Errorf("expected InRelease to sign Release.\nInRelease:\n%s\nRelease:\n%s", signed, release)
+	}
+
+	// tampering with a fetched file must break its signature
+	if err := ioutil.WriteFile(local("/yum/repodata/repomd.xml"), []byte("<repomd/>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := exec.Command("gpg", "--homedir", home, "--batch", "--verify", local("/yum/repodata/repomd.xml.asc"), local("/yum/repodata/repomd.xml")).Run(); err == nil {
+		t.Error("expected a modified repomd.xml to fail verification")
+	}
+
+	key, err := ioutil.ReadFile(local("/qri.asc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(key), "-----BEGIN PGP PUBLIC KEY BLOCK-----") {
+		t.Errorf("expected an armored public key, got:\n%s", key)
+	}
+}
+
+// checkAptRepository fetches an apt repository's indexes from baseURL,
+// checking the checksums they list against what's served
+func checkAptRepository(t *testing.T, baseURL string, rc RepositoryConfig, deb string) {
+	t.Helper()
+	dist := baseURL + "/dists/" + rc.Suite
+	release := fetch(t, dist+"/Release")
+
+	// every index listed under each checksum field must match the file
+	indexes := map[string]int{}
+	field := ""
+	sc := bufio.NewScanner(strings.NewReader(string(release)))
+	for sc.Scan() {
+		line := sc.Text()
+		if !strings.HasPrefix(line, " ") {
+			field = strings.TrimSuffix(line, ":")
+			continue
+		}
+		parts := strings.Fields(line)
+		if len(parts) != 3 {
+			t.Fatalf("malformed Release line %q", line)
+		}
+		data := fetch(t, dist+"/"+parts[2])
+		if size, _ := strconv.Atoi(parts[1]); size != len(data) {
+			t.Errorf("%s %s: expected size %d, Release lists %s", field, parts[2], len(data), parts[1])
+		}
+		if sum := repoTestSum(field, data); sum != parts[0] {
+			t.Errorf("%s %s: expected %s, Release lists %s", field, parts[2], sum, parts[0])
+		}
+		indexes[parts[2]]++
+	}
+	for _, name := range []string{"main/binary-amd64/Packages", "main/binary-amd64/Packages.gz"} {
+		if indexes[name] != 3 {
+			t.Errorf("expected %s to be listed under MD5Sum, SHA1 & SHA256, got %d entries", name, indexes[name])
+		}
+	}
+
+	fields := debControlFields(string(fetch(t, dist+"/main/binary-amd64/Packages")))
+	data, err := ioutil.ReadFile(deb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pooled := fetch(t, baseURL+"/"+fields["Filename"])
+	if string(pooled) != string(data) {
+		t.Error("expected the pooled deb to match the built deb")
+	}
+	expect := map[string]string{
+		"Package": "qri",
+		"Size":    strconv.Itoa(len(data)),
+		"MD5sum":  fmt.Sprintf("%x", md5.Sum(data)),
+		"SHA256":  fmt.Sprintf("%x", sha256.Sum256(data)),
+	}
+	for key, val := range expect {
+		if fields[key] != val {
+			t.Errorf("Packages %s: expected %q, got %q", key, val, fields[key])
+		}
+	}
+}
+
+func repoTestSum(field string, data []byte) string {
+	switch field {
+	case "MD5Sum":
+		return fmt.Sprintf("%x", md5.Sum(data))
+	case "SHA1":
+		return fmt.Sprintf("%x", sha1.Sum(data))
+	default:
+		return fmt.Sprintf("%x", sha256.Sum256(data))
+	}
+}
+
+// checkYumRepository fetches a yum repository's metadata from baseURL,
+// checking the checksums it lists against what's served
+func checkYumRepository(t *testing.T, baseURL, rpm string) {
+	t.Helper()
+	repomd := yumRepomd{}
+	if err := xml.Unmarshal(fetch(t, baseURL+"/repodata/repomd.xml"), &repomd); err != nil {
+		t.Fatal(err)
+	}
+	if len(repomd.Data) != 3 {
+		t.Fatalf("expected primary, filelists & other metadata, got %d entries", len(repomd.Data))
+	}
+
+	var primary []byte
+	for _, d := range repomd.Data {
+		gz := fetch(t, baseURL+"/"+d.Location.Href)
+		if sum := fmt.Sprintf("%x", sha256.Sum256(gz)); d.Checksum.Value != sum || d.Size != len(gz) {
+			t.Errorf("%s: expected checksum %s & size %d, repomd lists %s & %d", d.Type, sum, len(gz), d.Checksum.Value, d.Size)
+		}
+		gzr, err := gzip.NewReader(strings.NewReader(string(gz)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		open, err := ioutil.ReadAll(gzr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sum := fmt.Sprintf("%x", sha256.Sum256(open)); d.OpenChecksum.Value != sum || d.OpenSize != len(open) {
+			t.Errorf("%s: expected open checksum %s & size %d, repomd lists %s & %d", d.Type, sum, len(open), d.OpenChecksum.Value, d.OpenSize)
+		}
+		if d.Type == "primary" {
+			primary = open
+		}
+	}
+
+	doc := struct {
+		Packages []struct {
+			Name     string `xml:"name"`
+			Checksum struct {
+				Value string `xml:",chardata"`
+			} `xml:"checksum"`
+			Location struct {
+				Href string `xml:"href,attr"`
+			} `xml:"location"`
+		} `xml:"package"`
+	}{}
+	if err := xml.Unmarshal(primary, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Packages) != 1 || doc.Packages[0].Name != "qri" {
+		t.Fatalf("expected primary.xml to list qri, got %+v", doc.Packages)
+	}
+	data, err := ioutil.ReadFile(rpm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum := fmt.Sprintf("%x", sha256.Sum256(data)); doc.Packages[0].Checksum.Value != sum {
+		t.Errorf("expected package checksum %s, got %s", sum, doc.Packages[0].Checksum.Value)
+	}
+	if pkg := fetch(t, baseURL+"/"+doc.Packages[0].Location.Href); string(pkg) != string(data) {
+		t.Error("expected the listed package to match the built rpm")
+	}
+}
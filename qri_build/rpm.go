@@ -0,0 +1,505 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+)
+
+// rpm header value types
+const (
+	rpmTypeInt16       = 3
+	rpmTypeInt32       = 4
+	rpmTypeString      = 6
+	rpmTypeBin         = 7
+	rpmTypeStringArray = 8
+	rpmTypeI18NString  = 9
+)
+
+// rpm header & signature tags, see rpmtag.h
+const (
+	rpmTagHeaderSignatures = 62
+	rpmTagHeaderImmutable  = 63
+	rpmTagHeaderI18NTable  = 100
+
+	rpmSigTagSHA1        = 269
+	rpmSigTagSHA256      = 273
+	rpmSigTagSize        = 1000
+	rpmSigTagMD5         = 1004
+	rpmSigTagPayloadSize = 1007
+
+	rpmTagName              = 1000
+	rpmTagVersion           = 1001
+	rpmTagRelease           = 1002
+	rpmTagSummary           = 1004
+	rpmTagDescription       = 1005
+	rpmTagBuildTime         = 1006
+	rpmTagBuildHost         = 1007
+	rpmTagSize              = 1009
+	rpmTagVendor            = 1011
+	rpmTagLicense           = 1014
+	rpmTagPackager          = 1015
+	rpmTagGroup             = 1016
+	rpmTagURL               = 1020
+	rpmTagOS                = 1021
+	rpmTagArch              = 1022
+	rpmTagFileSizes         = 1028
+	rpmTagFileModes         = 1030
+	rpmTagFileRdevs         = 1033
+	rpmTagFileMtimes        = 1034
+	rpmTagFileDigests       = 1035
+	rpmTagFileLinkTos       = 1036
+	rpmTagFileFlags         = 1037
+	rpmTagFileUsername      = 1039
+	rpmTagFileGroupname     = 1040
+	rpmTagProvideName       = 1047
+	rpmTagRequireFlags      = 1048
+	rpmTagRequireName       = 1049
+	rpmTagRequireVersion    = 1050
+	rpmTagProvideFlags      = 1112
+	rpmTagProvideVersion    = 1113
+	rpmTagFileDevices       = 1095
+	rpmTagFileInodes        = 1096
+	rpmTagFileLangs         = 1097
+	rpmTagDirIndexes        = 1116
+	rpmTagBaseNames         = 1117
+	rpmTagDirNames          = 1118
+	rpmTagPayloadFormat     = 1124
+	rpmTagPayloadCompressor = 1125
+	rpmTagPayloadFlags      = 1126
+	rpmTagFileDigestAlgo    = 5011
+)
+
+const (
+	rpmSenseEqual       = 1 << 3
+	rpmSenseRpmlib      = 1 << 24
+	rpmFileFlagDoc      = 1 << 1
+	rpmDigestAlgoSHA256 = 8
+)
+
+func rpmArch(t Target) (string, error) {
+	switch t.Arch {
+	case "amd64":
+		return "x86_64", nil
+	case "386":
+		return "i686", nil
+	case "arm64":
+		return "aarch64", nil
+	case "ppc64le", "s390x":
+		return t.Arch, nil
+	case "arm":
+		if t.Variant == "v7" {
+			return "armv7hl", nil
+		}
+		return "armv6hl", nil
+	}
+	return "", fmt.Errorf("no rpm architecture for %s", t)
+}
+
+// rpmVersion converts to an rpm version. rpm versions can't contain "-",
+// pre-releases use "~" so they sort before the release
+func (p *linuxPackage) rpmVersion() string {
+	base, pre := splitVersion(p.Version)
+	if pre != "" {
+		return base + "~" + pre
+	}
+	return base
+}
+
+func (p *linuxPackage) rpmFilename() (string, error) {
+	arch, err := rpmArch(p.Target)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s-%s-1.%s.rpm", p.Name, p.rpmVersion(), arch), nil
+}
+
+// rpmHeader accumulates tagged values & serializes them in the rpm header
+// structure
+type rpmHeader struct {
+	entries []rpmEntry
+}
+
+type rpmEntry struct {
+	tag   int32
+	typ   int32
+	count int32
+	data  []byte
+}
+
+func (h *rpmHeader) add(tag, typ int32, count int, data []byte) {
+	h.entries = append(h.entries, rpmEntry{tag: tag, typ: typ, count: int32(count), data: data})
+}
+
+func (h *rpmHeader) String(tag int32, val string) {
+	h.add(tag, rpmTypeString, 1, append([]byte(val), 0))
+}
+
+func (h *rpmHeader) I18NString(tag int32, val string) {
+	h.add(tag, rpmTypeI18NString, 1, append([]byte(val), 0))
+}
+
+func (h *rpmHeader) StringArray(tag int32, vals []string) {
+	buf := &bytes.Buffer{}
+	for _, v := range vals {
+		buf.WriteString(v)
+		buf.WriteByte(0)
+	}
+	h.add(tag, rpmTypeStringArray, len(vals), buf.Bytes())
+}
+
+func (h *rpmHeader) Int32(tag int32, vals ...int32) {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.BigEndian, vals)
+	h.add(tag, rpmTypeInt32, len(vals), buf.Bytes())
+}
+
+func (h *rpmHeader) Int16(tag int32, vals ...int16) {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.BigEndian, vals)
+	h.add(tag, rpmTypeInt16, len(vals), buf.Bytes())
+}
+
+func (h *rpmHeader) Bin(tag int32, val []byte) {
+	h.add(tag, rpmTypeBin, len(val), val)
+}
+
+// Bytes serializes the header. regionTag is written first as the header's
+// immutable region, which rpm requires of both the signature & main headers
+func (h *rpmHeader) Bytes(regionTag int32) []byte {
+	entries := append([]rpmEntry(nil), h.entries...)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].tag < entries[j].tag })
+
+	var (
+		index = &bytes.Buffer{}
+		store = &bytes.Buffer{}
+	)
+	nindex := int32(len(entries) + 1)
+	for _, e := range entries {
+		// pad the store to the natural alignment of numeric types
+		align := map[int32]int{rpmTypeInt16: 2, rpmTypeInt32: 4}[e.typ]
+		for align > 0 && store.Len()%align != 0 {
+			store.WriteByte(0)
+		}
+		binary.Write(index, binary.BigEndian, []int32{e.tag, e.typ, int32(store.Len()), e.count})
+		store.Write(e.data)
+	}
+
+	// the region trailer is an index entry pointing back over the whole index
+	regionOffset := int32(store.Len())
+	binary.Write(store, binary.BigEndian, []int32{regionTag, rpmTypeBin, -nindex * 16, 16})
+
+	buf := &bytes.Buffer{}
+	buf.Write([]byte{0x8e, 0xad, 0xe8, 0x01, 0, 0, 0, 0})
+	binary.Write(buf, binary.BigEndian, []int32{nindex, int32(store.Len())})
+	binary.Write(buf, binary.BigEndian, []int32{regionTag, rpmTypeBin, regionOffset, 16})
+	buf.Write(index.Bytes())
+	buf.Write(store.Bytes())
+	return buf.Bytes()
+}
+
+// writeRPM writes an unsigned binary rpm package with a gzipped cpio payload
+func writeRPM(w io.Writer, p *linuxPackage) error {
+	arch, err := rpmArch(p.Target)
+	if err != nil {
+		return err
+	}
+
+	payload, payloadSize, err := p.rpmPayload()
+	if err != nil {
+		return err
+	}
+	header := p.rpmHeader(arch).Bytes(rpmTagHeaderImmutable)
+
+	sig := &rpmHeader{}
+	sig.String(rpmSigTagSHA1, fmt.Sprintf("%x", sha1.Sum(header)))
+	sig.String(rpmSigTagSHA256, fmt.Sprintf("%x", sha256.Sum256(header)))
+	sig.Int32(rpmSigTagSize, int32(len(header)+len(payload)))
+	sum := md5.New()
+	sum.Write(header)
+	sum.Write(payload)
+	sig.Bin(rpmSigTagMD5, sum.Sum(nil))
+	sig.Int32(rpmSigTagPayloadSize, int32(payloadSize))
+	sigHeader := sig.Bytes(rpmTagHeaderSignatures)
+	// the signature header is padded to an 8 byte boundary
+	for len(sigHeader)%8 != 0 {
+		sigHeader = append(sigHeader, 0)
+	}
+
+	lead := &bytes.Buffer{}
+	lead.Write([]byte{0xed, 0xab, 0xee, 0xdb, 3, 0})
+	binary.Write(lead, binary.BigEndian, []int16{0, 1})
+	name := make([]byte, 66)
+	copy(name, fmt.Sprintf("%s-%s-1", p.Name, p.rpmVersion()))
+	lead.Write(name)
+	binary.Write(lead, binary.BigEndian, []int16{1, 5})
+	lead.Write(make([]byte, 16))
+
+	for _, b := range [][]byte{lead.Bytes(), sigHeader, header, payload} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rpmHeader builds the main package header
+func (p *linuxPackage) rpmHeader(arch string) *rpmHeader {
+	h := &rpmHeader{}
+	h.StringArray(rpmTagHeaderI18NTable, []string{"C"})
+	h.String(rpmTagName, p.Name)
+	h.String(rpmTagVersion, p.rpmVersion())
+	h.String(rpmTagRelease, "1")
+	h.I18NString(rpmTagSummary, p.Summary)
+	h.I18NString(rpmTagDescription, p.Description)
+	h.Int32(rpmTagBuildTime, int32(p.BuildTime.Unix()))
+	if host, err := os.Hostname(); err == nil {
+		h.String(rpmTagBuildHost, host)
+	}
+	h.Int32(rpmTagSize, int32(p.installedSize()))
+	h.String(rpmTagVendor, p.Maintainer)
+	h.String(rpmTagLicense, p.License)
+	h.String(rpmTagPackager, p.Maintainer)
+	h.I18NString(rpmTagGroup, "Applications/System")
+	h.String(rpmTagURL, p.Homepage)
+	h.String(rpmTagOS, "linux")
+	h.String(rpmTagArch, arch)
+	h.String(rpmTagPayloadFormat, "cpio")
+	h.String(rpmTagPayloadCompressor, "gzip")
+	h.String(rpmTagPayloadFlags, "9")
+	h.Int32(rpmTagFileDigestAlgo, rpmDigestAlgoSHA256)
+
+	evr := p.rpmVersion() + "-1"
+	h.StringArray(rpmTagProvideName, []string{p.Name})
+	h.Int32(rpmTagProvideFlags, rpmSenseEqual)
+	h.StringArray(rpmTagProvideVersion, []string{evr})
+	h.StringArray(rpmTagRequireName, []string{"rpmlib(CompressedFileNames)", "rpmlib(FileDigests)", "rpmlib(PayloadFilesHavePrefix)"})
+	h.Int32(rpmTagRequireFlags, rpmSenseRpmlib|rpmSenseEqual, rpmSenseRpmlib|rpmSenseEqual, rpmSenseRpmlib|rpmSenseEqual)
+	h.StringArray(rpmTagRequireVersion, []string{"3.0.4-1", "4.6.0-1", "4.0-1"})
+
+	var (
+		sizes, mtimes, flags, devices, inodes, dirIndexes []int32
+		modes, rdevs                                      []int16
+		digests, linktos, users, groups, langs, basenames []string
+		dirnames                                          []string
+		dirIndex                                          = map[string]int32{}
+	)
+	for i, f := range p.Files {
+		dir := path.Dir(f.Path) + "/"
+		if _, ok := dirIndex[dir]; !ok {
+			dirIndex[dir] = int32(len(dirnames))
+			dirnames = append(dirnames, dir)
+		}
+		var flag int32
+		if path.Dir(f.Path) == "/usr/share/doc/"+p.Name {
+			flag = rpmFileFlagDoc
+		}
+
+		sizes = append(sizes, int32(len(f.Data)))
+		modes = append(modes, int16(0100000|f.Mode.Perm()))
+		rdevs = append(rdevs, 0)
+		mtimes = append(mtimes, int32(p.BuildTime.Unix()))
+		digests = append(digests, fmt.Sprintf("%x", sha256.Sum256(f.Data)))
+		linktos = append(linktos, "")
+		flags = append(flags, flag)
+		users = append(users, "root")
+		groups = append(groups, "root")
+		devices = append(devices, 1)
+		inodes = append(inodes, int32(i+1))
+		langs = append(langs, "")
+		dirIndexes = append(dirIndexes, dirIndex[dir])
+		basenames = append(basenames, path.Base(f.Path))
+	}
+	h.Int32(rpmTagFileSizes, sizes...)
+	h.Int16(rpmTagFileModes, modes...)
+	h.Int16(rpmTagFileRdevs, rdevs...)
+	h.Int32(rpmTagFileMtimes, mtimes...)
+	h.StringArray(rpmTagFileDigests, digests)
+	h.StringArray(rpmTagFileLinkTos, linktos)
+	h.Int32(rpmTagFileFlags, flags...)
+	h.StringArray(rpmTagFileUsername, users)
+	h.StringArray(rpmTagFileGroupname, groups)
+	h.Int32(rpmTagFileDevices, devices...)
+	h.Int32(rpmTagFileInodes, inodes...)
+	h.StringArray(rpmTagFileLangs, langs)
+	h.Int32(rpmTagDirIndexes, dirIndexes...)
+	h.StringArray(rpmTagBaseNames, basenames)
+	h.StringArray(rpmTagDirNames, dirnames)
+	return h
+}
+
+// rpmPayload writes the package files as a gzipped "newc" cpio archive,
+// returning the compressed payload & its uncompressed size
+func (p *linuxPackage) rpmPayload() ([]byte, int, error) {
+	raw := &bytes.Buffer{}
+	for i, f := range p.Files {
+		writeCpioEntry(raw, "."+f.Path, int64(i+1), 0100000|uint32(f.Mode.Perm()), p.BuildTime.Unix(), f.Data)
+	}
+	writeCpioEntry(raw, "TRAILER!!!", 0, 0, 0, nil)
+
+	buf := &bytes.Buffer{}
+	gzw, err := gzip.NewWriterLevel(buf, gzip.BestCompression)
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, err := gzw.Write(raw.Bytes()); err != nil {
+		return nil, 0, err
+	}
+	if err := gzw.Close(); err != nil {
+		return nil, 0, err
+	}
+	return buf.Bytes(), raw.Len(), nil
+}
+
+// writeCpioEntry writes a file in the SVR4 "newc" cpio format
+func writeCpioEntry(buf *bytes.Buffer, name string, ino int64, mode uint32, mtime int64, data []byte) {
+	nlink := 1
+	if name == "TRAILER!!!" {
+		nlink = 0
+	}
+	fmt.Fprintf(buf, "070701%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x",
+		ino, mode, 0, 0, nlink, mtime, len(data), 0, 0, 0, 0, len(name)+1, 0)
+	buf.WriteString(name)
+	buf.WriteByte(0)
+	for buf.Len()%4 != 0 {
+		buf.WriteByte(0)
+	}
+	buf.Write(data)
+	for buf.Len()%4 != 0 {
+		buf.WriteByte(0)
+	}
+}
+
+// rpmInfo is metadata read from an rpm package
+type rpmInfo struct {
+	Tags      map[int32]interface{}
+	Signature map[int32]interface{}
+	// HeaderStart & HeaderEnd are the byte range of the main header
+	HeaderStart int
+	HeaderEnd   int
+}
+
+// String returns a string tag value, or "" if the tag is absent
+func (r *rpmInfo) String(tag int32) string {
+	s, _ := r.Tags[tag].(string)
+	return s
+}
+
+// Strings returns a string array tag value
+func (r *rpmInfo) Strings(tag int32) []string {
+	s, _ := r.Tags[tag].([]string)
+	return s
+}
+
+// Int returns the first value of an integer tag
+func (r *rpmInfo) Int(tag int32) int {
+	return rpmInt(r.Tags[tag])
+}
+
+func rpmInt(val interface{}) int {
+	switch v := val.(type) {
+	case []int32:
+		if len(v) > 0 {
+			return int(v[0])
+		}
+	case []int16:
+		if len(v) > 0 {
+			return int(uint16(v[0]))
+		}
+	}
+	return 0
+}
+
+// readRPM reads the main header of an rpm package
+func readRPM(path string) (*rpmInfo, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 96 || !bytes.HasPrefix(data, []byte{0xed, 0xab, 0xee, 0xdb}) {
+		return nil, fmt.Errorf("%s is not an rpm package", path)
+	}
+
+	sig, sigEnd, err := parseRPMHeader(data, 96)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s signature: %s", path, err)
+	}
+	// the signature header is padded to an 8 byte boundary
+	start := (sigEnd + 7) / 8 * 8
+	tags, end, err := parseRPMHeader(data, start)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s header: %s", path, err)
+	}
+	return &rpmInfo{Tags: tags, Signature: sig, HeaderStart: start, HeaderEnd: end}, nil
+}
+
+// parseRPMHeader decodes the header structure starting at off, returning
+// tag values & the offset the header ends at
+func parseRPMHeader(data []byte, off int) (map[int32]interface{}, int, error) {
+	if off+16 > len(data) || !bytes.HasPrefix(data[off:], []byte{0x8e, 0xad, 0xe8, 0x01}) {
+		return nil, 0, fmt.Errorf("bad header magic")
+	}
+	nindex := int(binary.BigEndian.Uint32(data[off+8:]))
+	size := int(binary.BigEndian.Uint32(data[off+12:]))
+	index := off + 16
+	store := index + nindex*16
+	end := store + size
+	if end > len(data) {
+		return nil, 0, fmt.Errorf("truncated header")
+	}
+
+	tags := map[int32]interface{}{}
+	for i := 0; i < nindex; i++ {
+		e := data[index+i*16:]
+		tag := int32(binary.BigEndian.Uint32(e))
+		typ := int32(binary.BigEndian.Uint32(e[4:]))
+		offset := int(int32(binary.BigEndian.Uint32(e[8:])))
+		count := int(binary.BigEndian.Uint32(e[12:]))
+		if offset < 0 || store+offset > end {
+			continue
+		}
+		val := data[store+offset : end]
+
+		switch typ {
+		case rpmTypeString, rpmTypeI18NString:
+			if i := bytes.IndexByte(val, 0); i >= 0 {
+				tags[tag] = string(val[:i])
+			}
+		case rpmTypeStringArray:
+			strs := make([]string, 0, count)
+			for j := 0; j < count; j++ {
+				i := bytes.IndexByte(val, 0)
+				if i < 0 {
+					break
+				}
+				strs = append(strs, string(val[:i]))
+				val = val[i+1:]
+			}
+			tags[tag] = strs
+		case rpmTypeInt32:
+			ints := make([]int32, count)
+			if err := binary.Read(bytes.NewReader(val), binary.BigEndian, ints); err == nil {
+				tags[tag] = ints
+			}
+		case rpmTypeInt16:
+			ints := make([]int16, count)
+			if err := binary.Read(bytes.NewReader(val), binary.BigEndian, ints); err == nil {
+				tags[tag] = ints
+			}
+		case rpmTypeBin:
+			if count <= len(val) {
+				tags[tag] = val[:count]
+			}
+		}
+	}
+	return tags, end, nil
+}
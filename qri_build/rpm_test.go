@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testLinuxPackage is a package holding an executable & a doc file
+func testLinuxPackage(version string) *linuxPackage {
+	return &linuxPackage{
+		Name:        "qri",
+		Version:     version,
+		Summary:     "qri command-line client",
+		Description: "qri is a global dataset version control system",
+		Maintainer:  "qri, inc. <hello@qri.io>",
+		Homepage:    "https://qri.io",
+		License:     "GPL-3.0",
+		Target:      Target{OS: "linux", Arch: "amd64"},
+		BuildTime:   time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC),
+		Files: []packageFile{
+			{Path: "/usr/bin/qri", Data: []byte("qri binary"), Mode: 0755},
+			{Path: "/usr/share/doc/qri/copyright", Data: []byte("qri license\n"), Mode: 0644},
+		},
+	}
+}
+
+// cpioEntry is a file read from a "newc" cpio archive
+type cpioEntry struct {
+	Name  string
+	Mode  uint32
+	Mtime int64
+	Data  string
+}
+
+// readCpio reads every entry of a "newc" cpio archive, up to the trailer
+func readCpio(t *testing.T, data []byte) (entries []cpioEntry) {
+	t.Helper()
+	align := func(n int) int { return (n + 3) / 4 * 4 }
+	field := func(hdr []byte, i int) int64 {
+		v, err := strconv.ParseInt(string(hdr[6+i*8:14+i*8]), 16, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	for off := 0; off+110 <= len(data); {
+		hdr := data[off : off+110]
+		if string(hdr[:6]) != "070701" {
+			t.Fatalf("bad cpio magic %q at %d", hdr[:6], off)
+		}
+		mode, mtime, size, nameSize := field(hdr, 1), field(hdr, 5), int(field(hdr, 6)), int(field(hdr, 11))
+		name := string(data[off+110 : off+110+nameSize-1])
+		off = align(off + 110 + nameSize)
+		if name == "TRAILER!!!" {
+			return entries
+		}
+		entries = append(entries, cpioEntry{Name: name, Mode: uint32(mode), Mtime: mtime, Data: string(data[off : off+size])})
+		off = align(off + size)
+	}
+	t.Fatal("cpio archive has no trailer")
+	return nil
+}
+
+func TestWriteRPM(t *testing.T) {
+	p := testLinuxPackage("0.10.0-beta.1")
+	name, err := p.rpmFilename()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "qri-0.10.0~beta.1-1.x86_64.rpm" {
+		t.Errorf("unexpected rpm filename %s", name)
+	}
+	path := filepath.Join(t.TempDir(), name)
+	buf := &bytes.Buffer{}
+	if err := writeRPM(buf, p); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := readRPM(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for tag, expect := range map[int32]string{
+		rpmTagName:              "qri",
+		rpmTagVersion:           "0.10.0~beta.1",
+		rpmTagRelease:           "1",
+		rpmTagSummary:           p.Summary,
+		rpmTagDescription:       p.Description,
+		rpmTagLicense:           "GPL-3.0",
+		rpmTagURL:               "https://qri.io",
+		rpmTagOS:                "linux",
+		rpmTagArch:              "x86_64",
+		rpmTagPayloadFormat:     "cpio",
+		rpmTagPayloadCompressor: "gzip",
+	} {
+		if got := info.String(tag); got != expect {
+			t.Errorf("tag %d: expected %q, got %q", tag, expect, got)
+		}
+	}
+	if got := info.Int(rpmTagBuildTime); got != int(p.BuildTime.Unix()) {
+		t.Errorf("expected build time %d, got %d", p.BuildTime.Unix(), got)
+	}
+	if got := info.Int(rpmTagSize); got != 22 {
+		t.Errorf("expected an installed size of 22, got %d", got)
+	}
+	if got := info.Strings(rpmTagProvideVersion); !reflect.DeepEqual(got, []string{"0.10.0~beta.1-1"}) {
+		t.Errorf("unexpected provided version %v", got)
+	}
+
+	if got := info.Strings(rpmTagBaseNames); !reflect.DeepEqual(got, []string{"qri", "copyright"}) {
+		t.Errorf("unexpected base names %v", got)
+	}
+	if got := info.Strings(rpmTagDirNames); !reflect.DeepEqual(got, []string{"/usr/bin/", "/usr/share/doc/qri/"}) {
+		t.Errorf("unexpected dir names %v", got)
+	}
+	if got := info.Tags[rpmTagDirIndexes]; !reflect.DeepEqual(got, []int32{0, 1}) {
+		t.Errorf("unexpected dir indexes %v", got)
+	}
+	var modes []uint16
+	if got, ok := info.Tags[rpmTagFileModes].([]int16); ok {
+		for _, m := range got {
+			modes = append(modes, uint16(m))
+		}
+	}
+	if !reflect.DeepEqual(modes, []uint16{0100755, 0100644}) {
+		t.Errorf("unexpected file modes %o", modes)
+	}
+	if got := info.Tags[rpmTagFileSizes]; !reflect.DeepEqual(got, []int32{10, 12}) {
+		t.Errorf("unexpected file sizes %v", got)
+	}
+	if got := info.Tags[rpmTagFileFlags]; !reflect.DeepEqual(got, []int32{0, rpmFileFlagDoc}) {
+		t.Errorf("expected only the copyright to be flagged as a doc, got %v", got)
+	}
+	digests := info.Strings(rpmTagFileDigests)
+	for i, f := range p.Files {
+		if expect := fmt.Sprintf("%x", sha256.Sum256(f.Data)); i >= len(digests) || digests[i] != expect {
+			t.Errorf("%s: expected digest %s, got %v", f.Path, expect, digests)
+		}
+	}
+
+	data := buf.Bytes()
+	header, payload := data[info.HeaderStart:info.HeaderEnd], data[info.HeaderEnd:]
+	if got, _ := info.Signature[rpmSigTagSHA256].(string); got != fmt.Sprintf("%x", sha256.Sum256(header)) {
+		t.Errorf("signature sha256 doesn't match the header")
+	}
+	sum := md5.Sum(append(append([]byte{}, header...), payload...))
+	if got, _ := info.Signature[rpmSigTagMD5].([]byte); !bytes.Equal(got, sum[:]) {
+		t.Errorf("signature md5 doesn't match the header & payload")
+	}
+	if got := rpmInt(info.Signature[rpmSigTagSize]); got != len(header)+len(payload) {
+		t.Errorf("expected signature size %d, got %d", len(header)+len(payload), got)
+	}
+
+	gzr, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := ioutil.ReadAll(gzr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rpmInt(info.Signature[rpmSigTagPayloadSize]); got != len(raw) {
+		t.Errorf("expected payload size %d, got %d", len(raw), got)
+	}
+	expect := []cpioEntry{
+		{Name: "./usr/bin/qri", Mode: 0100755, Mtime: p.BuildTime.Unix(), Data: "qri binary"},
+		{Name: "./usr/share/doc/qri/copyright", Mode: 0100644, Mtime: p.BuildTime.Unix(), Data: "qri license\n"},
+	}
+	if got := readCpio(t, raw); !reflect.DeepEqual(got, expect) {
+		t.Errorf("payload mismatch.\nexpected: %+v\ngot:      %+v", expect, got)
+	}
+}
+
+func TestReadRPMRejectsOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "qri.rpm")
+	if err := ioutil.WriteFile(path, bytes.Repeat([]byte("not an rpm"), 20), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readRPM(path); err == nil {
+		t.Error("expected a file without the rpm lead to fail")
+	}
+}
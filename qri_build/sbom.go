@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// sbomFilename is the name of the software bill of materials included in
+// qri archives
+const sbomFilename = "sbom.cdx.json"
+
+// SBOM is a CycloneDX software bill of materials, listing the modules &
+// packages that ship in a release artifact
+type SBOM struct {
+	BOMFormat    string          `json:"bomFormat"`
+	SpecVersion  string          `json:"specVersion"`
+	SerialNumber string          `json:"serialNumber"`
+	Version      int             `json:"version"`
+	Metadata     SBOMMetadata    `json:"metadata"`
+	Components   []SBOMComponent `json:"components"`
+}
+
+// SBOMMetadata describes the subject of an SBOM
+type SBOMMetadata struct {
+	Timestamp time.Time     `json:"timestamp"`
+	Tools     []SBOMTool    `json:"tools"`
+	Component SBOMComponent `json:"component"`
+}
+
+// SBOMTool is the tool that generated an SBOM
+type SBOMTool struct {
+	Name string `json:"name"`
+}
+
+// SBOMComponent is a single application or library
+type SBOMComponent struct {
+	Type       string         `json:"type"`
+	BOMRef     string         `json:"bom-ref,omitempty"`
+	Name       string         `json:"name"`
+	Version    string         `json:"version,omitempty"`
+	PURL       string         `json:"purl,omitempty"`
+	Hashes     []SBOMHash     `json:"hashes,omitempty"`
+	Properties []SBOMProperty `json:"properties,omitempty"`
+}
+
+// SBOMHash is a component checksum
+type SBOMHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+// SBOMProperty is a name/value annotation
+type SBOMProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// NewSBOM creates an empty bill of materials for an application
+func NewSBOM(name, version string) *SBOM {
+	return &SBOM{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.4",
+		SerialNumber: "urn:uuid:" + uuid4(),
+		Version:      1,
+		Metadata: SBOMMetadata{
+			Timestamp: time.Now().UTC(),
+			Tools:     []SBOMTool{{Name: "qri_build"}},
+			Component: SBOMComponent{Type: "application", Name: name, Version: version},
+		},
+		Components: []SBOMComponent{},
+	}
+}
+
+// AddComponents appends components, skipping any already listed
+func (s *SBOM) AddComponents(cs ...SBOMComponent) {
+	seen := map[string]bool{}
+	for _, c := range s.Components {
+		seen[c.BOMRef] = true
+	}
+	for _, c := range cs {
+		if seen[c.BOMRef] {
+			continue
+		}
+		seen[c.BOMRef] = true
+		s.Components = append(s.Components, c)
+	}
+	sort.Slice(s.Components, func(i, j int) bool { return s.Components[i].BOMRef < s.Components[j].BOMRef })
+}
+
+// WriteFile writes the SBOM as JSON to path
+func (s *SBOM) WriteFile(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// GoBinarySBOM creates an SBOM from the module information embedded in a
+// compiled go binary, as reported by 'go version -m'
+func GoBinarySBOM(goBin, binPath, version string) (*SBOM, error) {
+	output, err := command{
+		String: "%s version -m",
+		Tmpl:   []interface{}{goBin},
+		Args:   []string{binPath},
+		Env:    goEnv(environ(nil)),
+	}.SecretRunStdout()
+	if err != nil {
+		return nil, fmt.Errorf("reading module info from %s: %s", binPath, err)
+	}
+	return parseGoVersionM(output, version)
+}
+
+// parseGoVersionM reads 'go version -m' output. the main module reports its
+// version as "(devel)", so version is used in its place
+func parseGoVersionM(output, version string) (*SBOM, error) {
+	var (
+		s     *SBOM
+		deps  []SBOMComponent
+		props []SBOMProperty
+	)
+
+	for _, line := range strings.Split(output, "\n") {
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "\t") {
+			// header line, "path/to/binary: go1.x.y"
+			if i := strings.LastIndex(line, ": "); i >= 0 {
+				props = append(props, SBOMProperty{Name: "go:version", Value: strings.TrimSpace(line[i+2:])})
+			}
+			continue
+		}
+
+		fields := strings.Split(strings.TrimPrefix(line, "\t"), "\t")
+		switch fields[0] {
+		case "path":
+		case "mod":
+			if len(fields) < 2 {
+				continue
+			}
+			s = NewSBOM(fields[1], version)
+			s.Metadata.Component.BOMRef = goPURL(fields[1], version)
+			s.Metadata.Component.PURL = s.Metadata.Component.BOMRef
+		case "dep":
+			if len(fields) < 3 {
+				continue
+			}
+			deps = append(deps, goComponent(fields[1], fields[2], fields[3:]))
+		case "=>":
+			// replaces the preceding dep
+			if len(fields) < 3 || len(deps) == 0 {
+				continue
+			}
+			replaced := deps[len(deps)-1]
+			c := goComponent(fields[1], fields[2], fields[3:])
+			c.Properties = append(c.Properties, SBOMProperty{Name: "go:replaces", Value: replaced.Name + "@" + replaced.Version})
+			deps[len(deps)-1] = c
+		case "build":
+			if len(fields) < 2 {
+				continue
+			}
+			if kv := strings.SplitN(fields[1], "=", 2); len(kv) == 2 {
+				props = append(props, SBOMProperty{Name: "go:build:" + kv[0], Value: kv[1]})
+			}
+		}
+	}
+
+	if s == nil {
+		return nil, fmt.Errorf("no module information found. was the binary built with go modules?")
+	}
+	s.Metadata.Component.Properties = props
+	s.AddComponents(deps...)
+	return s, nil
+}
+
+func goComponent(path, version string, sum []string) SBOMComponent {
+	c := SBOMComponent{
+		Type:    "library",
+		BOMRef:  goPURL(path, version),
+		Name:    path,
+		Version: version,
+		PURL:    goPURL(path, version),
+	}
+	if len(sum) > 0 && sum[0] != "" {
+		// go.sum hashes cover a module's file tree, not an archive, so they
+		// don't fit CycloneDX's hash algorithms
+		c.Properties = append(c.Properties, SBOMProperty{Name: "go:sum", Value: sum[0]})
+	}
+	return c
+}
+
+func goPURL(path, version string) string {
+	return fmt.Sprintf("pkg:golang/%s@%s", path, url.PathEscape(version))
+}
+
+// YarnLockComponents lists the npm packages pinned in a yarn.lock file
+func YarnLockComponents(path string) ([]SBOMComponent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		cs   []SBOMComponent
+		cur  *SBOMComponent
+		seen = map[string]bool{}
+	)
+	flush := func() {
+		if cur != nil && cur.Version != "" && !seen[cur.BOMRef] {
+			seen[cur.BOMRef] = true
+			cs = append(cs, *cur)
+		}
+		cur = nil
+	}
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if !strings.HasPrefix(line, " ") {
+			// entry header: one or more comma-separated "name@range" specs
+			flush()
+			spec := strings.TrimSuffix(line, ":")
+			spec = strings.TrimSpace(strings.Split(spec, ",")[0])
+			spec = strings.Trim(spec, `"`)
+			if name := yarnPackageName(spec); name != "" {
+				cur = &SBOMComponent{Type: "library", Name: name}
+			}
+			continue
+		}
+
+		if cur == nil {
+			continue
+		}
+		key, val := yarnField(strings.TrimSpace(line))
+		switch key {
+		case "version":
+			cur.Version = val
+			cur.PURL = npmPURL(cur.Name, val)
+			cur.BOMRef = cur.PURL
+		case "integrity", "checksum":
+			if h, ok := yarnIntegrityHash(val); ok {
+				cur.Hashes = []SBOMHash{h}
+			}
+		}
+	}
+	flush()
+	return cs, sc.Err()
+}
+
+// yarnPackageName trims the version range from a yarn.lock spec like
+// "@babel/core@^7.0.0" or "react@npm:16.13.1"
+func yarnPackageName(spec string) string {
+	i := strings.LastIndex(spec, "@")
+	if i <= 0 {
+		return ""
+	}
+	return spec[:i]
+}
+
+// yarnField splits a yarn.lock field in either the v1 (`version "1.0.0"`) or
+// berry (`version: 1.0.0`) syntax
+func yarnField(line string) (key, val string) {
+	i := strings.IndexAny(line, " :")
+	if i < 0 {
+		return line, ""
+	}
+	key = line[:i]
+	val = strings.TrimSpace(strings.TrimPrefix(line[i:], ":"))
+	return key, strings.Trim(val, `"`)
+}
+
+// yarnIntegrityHash converts a subresource integrity string like
+// "sha512-<base64>" to a CycloneDX hash. empty integrity fields have no hash
+func yarnIntegrityHash(integrity string) (SBOMHash, bool) {
+	algs := map[string]string{"sha1": "SHA-1", "sha256": "SHA-256", "sha512": "SHA-512"}
+	fields := strings.Fields(integrity)
+	if len(fields) == 0 {
+		return SBOMHash{}, false
+	}
+	parts := strings.SplitN(fields[0], "-", 2)
+	if len(parts) != 2 || algs[parts[0]] == "" {
+		return SBOMHash{}, false
+	}
+	sum, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return SBOMHash{}, false
+	}
+	return SBOMHash{Alg: algs[parts[0]], Content: hex.EncodeToString(sum)}, true
+}
+
+func npmPURL(name, version string) string {
+	// scoped package namespaces are percent-encoded: pkg:npm/%40scope/name
+	return fmt.Sprintf("pkg:npm/%s@%s", strings.Replace(name, "@", "%40", 1), url.PathEscape(version))
+}
+
+// uuid4 generates a random (version 4) UUID
+func uuid4() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
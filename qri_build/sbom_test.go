@@ -0,0 +1,71 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestYarnIntegrityHash(t *testing.T) {
+	cases := []struct {
+		integrity string
+		alg       string
+		content   string
+	}{
+		{"sha512-3q2+7w==", "SHA-512", "deadbeef"},
+		{"sha1-3q2+7w== sha512-AAAA", "SHA-1", "deadbeef"},
+		{"md5-3q2+7w==", "", ""},
+		{"sha512-not base64!", "", ""},
+		{"", "", ""},
+		{"   ", "", ""},
+	}
+	for _, c := range cases {
+		h, ok := yarnIntegrityHash(c.integrity)
+		if c.alg == "" {
+			if ok {
+				t.Errorf("%q: expected no hash, got %v", c.integrity, h)
+			}
+			continue
+		}
+		if !ok || h.Alg != c.alg || h.Content != c.content {
+			t.Errorf("%q: expected %s %s, got %v", c.integrity, c.alg, c.content, h)
+		}
+	}
+}
+
+func TestYarnLockComponents(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"yarn.lock": `# THIS IS AN AUTOGENERATED FILE. DO NOT EDIT THIS FILE DIRECTLY.
+# yarn lockfile v1
+
+
+"@babel/core@^7.0.0":
+  version "7.9.0"
+  resolved "https://registry.yarnpkg.com/@babel/core/-/core-7.9.0.tgz"
+  integrity sha512-3q2+7w==
+
+left-pad@^1.3.0:
+  version "1.3.0"
+  resolved "https://registry.yarnpkg.com/left-pad/-/left-pad-1.3.0.tgz"
+  integrity ""
+`})
+
+	cs, err := YarnLockComponents(filepath.Join(dir, "yarn.lock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cs) != 2 {
+		t.Fatalf("expected 2 components, got %d: %v", len(cs), cs)
+	}
+	byName := map[string]SBOMComponent{}
+	for _, c := range cs {
+		byName[c.Name] = c
+	}
+	babel := byName["@babel/core"]
+	if babel.PURL != "pkg:npm/%40babel/core@7.9.0" || len(babel.Hashes) != 1 {
+		t.Errorf("unexpected @babel/core component %+v", babel)
+	}
+	pad := byName["left-pad"]
+	if pad.Version != "1.3.0" || len(pad.Hashes) != 0 {
+		t.Errorf("expected left-pad without a hash, got %+v", pad)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Signer codesigns build outputs. Implementations are chosen with the
+// "codesign" section of the --config file
+type Signer interface {
+	// SignBinary signs an executable in place, before it's packaged
+	SignBinary(path string) (*Signature, error)
+	// SignDistributable signs & notarizes an archive or installer that will
+	// be shipped to users. it returns nil if the file was neither signed nor
+	// notarized
+	SignDistributable(path string) (*Signature, error)
+}
+
+// Signature records how an artifact was signed
+type Signature struct {
+	Signer    string    `json:"signer"`
+	Identity  string    `json:"identity"`
+	Notarized bool      `json:"notarized,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// CodesignConfig configures the Signer
+type CodesignConfig struct {
+	// Signer is one of "macos", "fake" or "none". defaults to "none"
+	Signer string `json:"signer"`
+	// Identity is the signing identity, eg.
+	// "Developer ID Application: Qri, Inc. (TEAMID)"
+	Identity string `json:"identity"`
+	// KeychainProfile is the notarytool keychain profile holding notarization
+	// credentials, created with 'xcrun notarytool store-credentials'. when
+	// empty, artifacts are signed but not notarized
+	KeychainProfile string `json:"keychainProfile"`
+	// Entitlements is an optional entitlements plist for signed binaries
+	Entitlements string `json:"entitlements"`
+}
+
+// NewSigner creates the Signer described by configuration
+func NewSigner(c CodesignConfig) (Signer, error) {
+	switch c.Signer {
+	case "", "none":
+		return NopSigner{}, nil
+	case "fake":
+		return &FakeSigner{Identity: c.Identity}, nil
+	case "macos":
+		if c.Identity == "" {
+			return nil, fmt.Errorf("codesign identity is required for the macos signer")
+		}
+		return &MacSigner{Identity: c.Identity, KeychainProfile: c.KeychainProfile, Entitlements: c.Entitlements}, nil
+	}
+	return nil, fmt.Errorf("unknown signer %q", c.Signer)
+}
+
+// NopSigner signs nothing. artifacts it handles are recorded as unsigned
+type NopSigner struct{}
+
+// SignBinary implements the Signer interface
+func (NopSigner) SignBinary(path string) (*Signature, error) { return nil, nil }
+
+// SignDistributable implements the Signer interface
+func (NopSigner) SignDistributable(path string) (*Signature, error) { return nil, nil }
+
+// FakeSigner records the files it's asked to sign without touching them. It
+// lets signing flows run on linux CI
+type FakeSigner struct {
+	Identity string
+
+	lk    sync.Mutex
+	Paths []string
+}
+
+// SignBinary implements the Signer interface
+func (s *FakeSigner) SignBinary(path string) (*Signature, error) {
+	return s.sign(path, false)
+}
+
+// SignDistributable implements the Signer interface
+func (s *FakeSigner) SignDistributable(path string) (*Signature, error) {
+	return s.sign(path, true)
+}
+
+func (s *FakeSigner) sign(path string, notarize bool) (*Signature, error) {
+	s.lk.Lock()
+	s.Paths = append(s.Paths, path)
+	s.lk.Unlock()
+	log.Infof("fake signing %s", path)
+	return &Signature{Signer: "fake", Identity: s.Identity, Notarized: notarize, Time: time.Now().UTC()}, nil
+}
+
+// MacSigner signs with codesign & notarizes with notarytool. Both ship with
+// xcode, so it only works on macOS
+type MacSigner struct {
+	Identity        string
+	KeychainProfile string
+	Entitlements    string
+}
+
+// SignBinary signs an executable with the hardened runtime enabled, which
+// notarization requires
+func (s *MacSigner) SignBinary(path string) (*Signature, error) {
+	args := []string{"--force", "--timestamp", "--options", "runtime", "--sign", s.Identity}
+	if s.Entitlements != "" {
+		args = append(args, "--entitlements", s.Entitlements)
+	}
+	if err := (command{String: "codesign", Args: append(args, path)}).Run(); err != nil {
+		return nil, fmt.Errorf("signing %s: %s", path, err)
+	}
+	if err := (command{String: "codesign", Args: []string{"--verify", "--strict", path}}).Run(); err != nil {
+		return nil, fmt.Errorf("verifying signature of %s: %s", path, err)
+	}
+	return &Signature{Signer: "macos", Identity: s.Identity, Time: time.Now().UTC()}, nil
+}
+
+// SignDistributable signs a disk image, then submits it for notarization &
+// staples the ticket. zip archives can't be signed or stapled, they're only
+// notarized, covering the signed binaries inside. without a keychain profile
+// a zip gets no signature of its own, so it returns nil
+func (s *MacSigner) SignDistributable(path string) (*Signature, error) {
+	sig := &Signature{Signer: "macos", Identity: s.Identity, Time: time.Now().UTC()}
+	ext := strings.ToLower(filepath.Ext(path))
+
+	if ext == ".dmg" {
+		args := []string{"--force", "--timestamp", "--sign", s.Identity, path}
+		if err := (command{String: "codesign", Args: args}).Run(); err != nil {
+			return nil, fmt.Errorf("signing %s: %s", path, err)
+		}
+	}
+
+	if s.KeychainProfile == "" {
+		if ext != ".dmg" {
+			log.Warnf("no notarization keychain profile configured, %s is not notarized", path)
+			return nil, nil
+		}
+		log.Warnf("no notarization keychain profile configured, %s is signed but not notarized", path)
+		return sig, nil
+	}
+
+	submit := command{
+		String: "xcrun notarytool submit",
+		Args:   []string{path, "--keychain-profile", s.KeychainProfile, "--wait"},
+		Retry:  notaryRetryPolicy,
+	}
+	if err := submit.Run(); err != nil {
+		return nil, fmt.Errorf("notarizing %s: %s", path, err)
+	}
+	if ext == ".dmg" {
+		if err := (command{String: "xcrun stapler staple", Args: []string{path}}).Run(); err != nil {
+			return nil, fmt.Errorf("stapling %s: %s", path, err)
+		}
+	}
+	sig.Notarized = true
+	return sig, nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMacSignerSignDistributable(t *testing.T) {
+	cases := []struct {
+		description string
+		path        string
+		profile     string
+		signed      bool
+		notarized   bool
+		lines       []string
+	}{
+		{"zip without a profile", "qri_darwin_amd64.zip", "", false, false, nil},
+		{"zip", "qri_darwin_amd64.zip", "qri-notary", true, true, []string{
+			"xcrun notarytool submit qri_darwin_amd64.zip --keychain-profile qri-notary --wait",
+		}},
+		{"dmg without a profile", "Qri.dmg", "", true, false, []string{
+			"codesign --force --timestamp --sign Developer ID Application: Qri Qri.dmg",
+		}},
+		{"dmg", "Qri.dmg", "qri-notary", true, true, []string{
+			"codesign --force --timestamp --sign Developer ID Application: Qri Qri.dmg",
+			"xcrun notarytool submit Qri.dmg --keychain-profile qri-notary --wait",
+			"xcrun stapler staple Qri.dmg",
+		}},
+	}
+	for _, c := range cases {
+		fake := &RecordingExecutor{}
+		useExecutor(t, fake)
+		s := &MacSigner{Identity: "Developer ID Application: Qri", KeychainProfile: c.profile}
+
+		sig, err := s.SignDistributable(c.path)
+		if err != nil {
+			t.Errorf("%s: %s", c.description, err)
+			continue
+		}
+		if !c.signed {
+			if sig != nil {
+				t.Errorf("%s: expected no signature, got %+v", c.description, sig)
+			}
+		} else if sig == nil || sig.Notarized != c.notarized || sig.Identity != s.Identity {
+			t.Errorf("%s: expected a signature with notarized %t, got %+v", c.description, c.notarized, sig)
+		}
+		if lines := fake.Lines(); len(lines) != len(c.lines) || (len(lines) > 0 && !reflect.DeepEqual(lines, c.lines)) {
+			t.Errorf("%s: expected commands %q, got %q", c.description, c.lines, lines)
+		}
+	}
+}
+
+func TestBuildQriZipKeepsBinarySignature(t *testing.T) {
+	repo := fakeQriRepo(t)
+	fake := &RecordingExecutor{Responses: fakeGoResponses(t, t.TempDir()), Fallback: fakeGoBuild}
+	delete(fake.Responses, "go build")
+	useExecutor(t, fake)
+	chdir(t, t.TempDir())
+
+	templates, err := LoadArchiveTemplates("", repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := LoadManifest(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := Target{OS: "darwin", Arch: "arm64"}
+	signer := &MacSigner{Identity: "Developer ID Application: Qri"}
+	if err := BuildQriZip(target, repo, "", templates, signer, manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	var archive *Artifact
+	for _, a := range manifest.Artifacts {
+		if a.Name == zipName(target) {
+			archive = a
+		}
+	}
+	if archive == nil {
+		t.Fatalf("expected %s in the manifest", zipName(target))
+	}
+	if archive.Signature == nil || archive.Signature.Identity != signer.Identity || archive.Signature.Notarized {
+		t.Errorf("expected the zip to carry the binary's signature, got %+v", archive.Signature)
+	}
+	for _, line := range fake.Lines() {
+		if strings.HasPrefix(line, "codesign") && strings.Contains(line, ".zip") {
+			t.Errorf("expected the zip not to be codesigned, ran %q", line)
+		}
+	}
+}
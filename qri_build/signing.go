@@ -0,0 +1,462 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// SigningKeyEnvVar holds a secret signing key, for CI environments where
+// writing the key to disk isn't desirable
+const SigningKeyEnvVar = "QRI_BUILD_SIGNING_KEY"
+
+// SignCmd signs release artifacts
+var SignCmd = &cobra.Command{
+	Use:   "sign",
+	Short: "write detached signatures & a signed SHA256SUMS for release artifacts",
+	Long: `
+sign writes a SHA256SUMS file listing manifest.json & every artifact it lists,
+then a detached minisign-compatible signature (<file>.minisig) for each of them
+and for SHA256SUMS itself. Other files in the release directory aren't signed. --dir is required: 'qri_build qri' writes releases to
+the working directory, 'qri_build desktop' to output/.
+
+The secret key is read from the file given by --key (or "signing.keyFile" in the
+--config file), or from the ` + SigningKeyEnvVar + ` environment variable.
+Create a key pair with 'qri_build keygen'. Secret keys use qri_build's own
+unencrypted format, minisign secret keys can't be used.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		dir, err := releaseDir(cmd)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		keyFile, err := cmd.Flags().GetString("key")
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		if keyFile != "" {
+			cfg.Signing.KeyFile = keyFile
+		}
+
+		key, err := LoadSigningKey(cfg.Signing)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		if err := SignRelease(dir, key); err != nil {
+			log.Errorf("signing release: %s", err)
+		}
+	},
+}
+
+// VerifyCmd checks release signatures
+var VerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "verify the signatures & checksums of a release directory",
+	Run: func(cmd *cobra.Command, args []string) {
+		dir, err := releaseDir(cmd)
+		if err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+
+		pubKeyStr, err := cmd.Flags().GetString("pubkey")
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		if pubKeyStr == "" {
+			pubKeyStr = cfg.Signing.PublicKey
+		}
+
+		pub, err := LoadPublicKey(pubKeyStr)
+		if err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+
+		if err := VerifyRelease(dir, pub); err != nil {
+			log.Errorf("verifying release: %s", err)
+			os.Exit(1)
+		}
+		fmt.Printf("release %s verified\n", dir)
+	},
+}
+
+// KeygenCmd creates a signing key pair
+var KeygenCmd = &cobra.Command{
+	Use:   "keygen",
+	Short: "create a release signing key pair",
+	Run: func(cmd *cobra.Command, args []string) {
+		path, err := cmd.Flags().GetString("out")
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		key, err := GenerateSigningKey()
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		if err := ioutil.WriteFile(path, []byte(key.String()), 0600); err != nil {
+			log.Error(err)
+			return
+		}
+		pub := key.Public()
+		if err := ioutil.WriteFile(path+".pub", []byte(pub.File()), 0644); err != nil {
+			log.Error(err)
+			return
+		}
+		fmt.Printf("wrote secret key to %s\npublic key: %s\n", path, pub)
+	},
+}
+
+func init() {
+	SignCmd.Flags().String("dir", "", releaseDirUsage)
+	SignCmd.Flags().String("key", "", "path to secret signing key file")
+	VerifyCmd.Flags().String("dir", "", releaseDirUsage)
+	VerifyCmd.Flags().String("pubkey", "", "public key, or path to a public key file")
+	KeygenCmd.Flags().String("out", "qri_build.key", "path to write the secret key to. the public key is written alongside with a .pub extension")
+}
+
+// SigningConfig configures release signing
+type SigningConfig struct {
+	// KeyFile is the path to the secret signing key
+	KeyFile string `json:"keyFile"`
+	// PublicKey is the public key, or the path to a public key file, that
+	// releases are verified against
+	PublicKey string `json:"publicKey"`
+}
+
+// signatures use minisign's legacy "Ed" algorithm: plain ed25519 over the
+// file contents, so they can be checked with minisign as well as qri_build
+var sigAlgorithm = []byte("Ed")
+
+const (
+	checksumsFile = "SHA256SUMS"
+	sigExt        = ".minisig"
+	keyIDLen      = 8
+)
+
+// SigningKey is a secret ed25519 key with a minisign-style key id. Its file
+// format is qri_build's own, not minisign's: it isn't encrypted, so it can't
+// be read by minisign & qri_build can't read minisign secret keys
+type SigningKey struct {
+	ID  [keyIDLen]byte
+	Key ed25519.PrivateKey
+}
+
+// PublicKey is a public ed25519 key with a minisign-style key id
+type PublicKey struct {
+	ID  [keyIDLen]byte
+	Key ed25519.PublicKey
+}
+
+// GenerateSigningKey creates a new random key
+func GenerateSigningKey() (*SigningKey, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	key := &SigningKey{Key: priv}
+	if _, err := rand.Read(key.ID[:]); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// String encodes the secret key as a commented base64 line holding the
+// algorithm, key id & ed25519 key
+func (k *SigningKey) String() string {
+	buf := append(append(append([]byte{}, sigAlgorithm...), k.ID[:]...), k.Key...)
+	return "untrusted comment: qri_build secret key\n" + base64.StdEncoding.EncodeToString(buf) + "\n"
+}
+
+// Public returns the public half of the key
+func (k *SigningKey) Public() *PublicKey {
+	return &PublicKey{ID: k.ID, Key: k.Key.Public().(ed25519.PublicKey)}
+}
+
+// String encodes the public key in minisign's format
+func (p *PublicKey) String() string {
+	buf := append(append(append([]byte{}, sigAlgorithm...), p.ID[:]...), p.Key...)
+	return base64.StdEncoding.EncodeToString(buf)
+}
+
+// File formats the public key as a minisign public key file
+func (p *PublicKey) File() string {
+	return fmt.Sprintf("untrusted comment: qri_build public key %X\n%s\n", p.ID, p)
+}
+
+// lastLine returns the last non-empty line of a key file, skipping comments
+func lastLine(data string) string {
+	lines := strings.Split(strings.TrimSpace(data), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// ParseSigningKey decodes a secret key written by SigningKey.String
+func ParseSigningKey(data string) (*SigningKey, error) {
+	buf, err := base64.StdEncoding.DecodeString(lastLine(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid secret key: %s", err)
+	}
+	if len(buf) != 2+keyIDLen+ed25519.PrivateKeySize || !bytes.Equal(buf[:2], sigAlgorithm) {
+		return nil, fmt.Errorf("invalid secret key")
+	}
+	key := &SigningKey{Key: ed25519.PrivateKey(buf[2+keyIDLen:])}
+	copy(key.ID[:], buf[2:])
+	return key, nil
+}
+
+// ParsePublicKey decodes a minisign public key
+func ParsePublicKey(data string) (*PublicKey, error) {
+	buf, err := base64.StdEncoding.DecodeString(lastLine(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %s", err)
+	}
+	if len(buf) != 2+keyIDLen+ed25519.PublicKeySize || !bytes.Equal(buf[:2], sigAlgorithm) {
+		return nil, fmt.Errorf("invalid public key")
+	}
+	pub := &PublicKey{Key: ed25519.PublicKey(buf[2+keyIDLen:])}
+	copy(pub.ID[:], buf[2:])
+	return pub, nil
+}
+
+// LoadSigningKey reads the secret key from the configured file, falling back
+// to the SigningKeyEnvVar environment variable
+func LoadSigningKey(c SigningConfig) (*SigningKey, error) {
+	if c.KeyFile != "" {
+		data, err := ioutil.ReadFile(c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return ParseSigningKey(string(data))
+	}
+	if data := os.Getenv(SigningKeyEnvVar); data != "" {
+		return ParseSigningKey(data)
+	}
+	return nil, fmt.Errorf("no signing key. set --key or %s", SigningKeyEnvVar)
+}
+
+// LoadPublicKey parses a public key string. anything that isn't a valid key
+// is read as the path to a public key file
+func LoadPublicKey(keyOrPath string) (*PublicKey, error) {
+	if keyOrPath == "" {
+		return nil, fmt.Errorf("a public key is required")
+	}
+	if pub, err := ParsePublicKey(keyOrPath); err == nil {
+		return pub, nil
+	}
+	data, err := ioutil.ReadFile(keyOrPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading public key: %s", err)
+	}
+	return ParsePublicKey(string(data))
+}
+
+// Sign creates a minisign-format detached signature for data
+func (k *SigningKey) Sign(data []byte, filename string) []byte {
+	sig := ed25519.Sign(k.Key, data)
+	trusted := fmt.Sprintf("timestamp:%d\tfile:%s", time.Now().Unix(), filename)
+	global := ed25519.Sign(k.Key, append(append([]byte{}, sig...), trusted...))
+
+	sigLine := append(append(append([]byte{}, sigAlgorithm...), k.ID[:]...), sig...)
+	return []byte(fmt.Sprintf("untrusted comment: signature from qri_build secret key\n%s\ntrusted comment: %s\n%s\n",
+		base64.StdEncoding.EncodeToString(sigLine), trusted, base64.StdEncoding.EncodeToString(global)))
+}
+
+// Verify checks a minisign-format detached signature of data
+func (p *PublicKey) Verify(data, signature []byte) error {
+	lines := strings.Split(strings.TrimSpace(string(signature)), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return fmt.Errorf("malformed signature")
+	}
+	sigLine, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(sigLine) != 2+keyIDLen+ed25519.SignatureSize {
+		return fmt.Errorf("malformed signature")
+	}
+	if !bytes.Equal(sigLine[:2], sigAlgorithm) {
+		return fmt.Errorf("unsupported signature algorithm %q", sigLine[:2])
+	}
+	if !bytes.Equal(sigLine[2:2+keyIDLen], p.ID[:]) {
+		return fmt.Errorf("signed by key %X, not %X", sigLine[2:2+keyIDLen], p.ID)
+	}
+	sig := sigLine[2+keyIDLen:]
+	if !ed25519.Verify(p.Key, data, sig) {
+		return fmt.Errorf("invalid signature")
+	}
+
+	trusted := strings.TrimPrefix(strings.TrimRight(lines[2], "\r"), "trusted comment: ")
+	global, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil || !ed25519.Verify(p.Key, append(append([]byte{}, sig...), trusted...), global) {
+		return fmt.Errorf("invalid trusted comment signature")
+	}
+	return nil
+}
+
+// releaseArtifacts lists the files in a release directory that should be
+// checksummed & signed: manifest.json & the artifacts it lists, as slash
+// separated paths relative to dir. anything else in dir, like source
+// checkouts next to a release in the working directory, is left out
+func releaseArtifacts(dir string) ([]string, error) {
+	if _, err := os.Stat(filepath.Join(dir, manifestFilename)); err != nil {
+		return nil, fmt.Errorf("reading release manifest: %s", err)
+	}
+	m, err := LoadManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{manifestFilename}
+	for _, a := range m.Artifacts {
+		name := path.Clean(a.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return nil, fmt.Errorf("%s lists artifact %q outside the release directory", manifestFilename, a.Name)
+		}
+		files = append(files, name)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// SignRelease writes SHA256SUMS for manifest.json & every artifact it lists,
+// then signs each of them & the checksums file
+func SignRelease(dir string, key *SigningKey) error {
+	files, err := releaseArtifacts(dir)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no artifacts listed in %s", filepath.Join(dir, manifestFilename))
+	}
+
+	sums := &bytes.Buffer{}
+	for _, name := range files {
+		data, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(sums, "%x  %s\n", sha256.Sum256(data), name)
+		if err := writeSignature(dir, name, data, key); err != nil {
+			return err
+		}
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, checksumsFile), sums.Bytes(), 0644); err != nil {
+		return err
+	}
+	if err := writeSignature(dir, checksumsFile, sums.Bytes(), key); err != nil {
+		return err
+	}
+	log.Infof("signed %d artifacts in %s with key %X", len(files), dir, key.ID)
+	return nil
+}
+
+func writeSignature(dir, name string, data []byte, key *SigningKey) error {
+	sig := key.Sign(data, filepath.Base(name))
+	return ioutil.WriteFile(filepath.Join(dir, filepath.FromSlash(name)+sigExt), sig, 0644)
+}
+
+// VerifyRelease checks the signed SHA256SUMS in dir, that manifest.json &
+// every artifact it lists match their checksums & that each carries a valid
+// signature
+func VerifyRelease(dir string, pub *PublicKey) error {
+	sums, err := ioutil.ReadFile(filepath.Join(dir, checksumsFile))
+	if err != nil {
+		return err
+	}
+	if err := verifyFile(dir, checksumsFile, sums, pub); err != nil {
+		return err
+	}
+
+	expected, err := parseChecksums(bytes.NewReader(sums))
+	if err != nil {
+		return err
+	}
+	files, err := releaseArtifacts(dir)
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+	for _, name := range files {
+		sum, ok := expected[name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s: not listed in %s", name, checksumsFile))
+			continue
+		}
+		delete(expected, name)
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			return err
+		}
+		if fmt.Sprintf("%x", sha256.Sum256(data)) != sum {
+			problems = append(problems, fmt.Sprintf("%s: checksum mismatch", name))
+			continue
+		}
+		if err := verifyFile(dir, name, data, pub); err != nil {
+			problems = append(problems, err.Error())
+			continue
+		}
+		log.Infof("ok %s", name)
+	}
+	for name := range expected {
+		problems = append(problems, fmt.Sprintf("%s: listed in %s but missing", name, checksumsFile))
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("%d problems:\n  %s", len(problems), strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+func verifyFile(dir, name string, data []byte, pub *PublicKey) error {
+	sig, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)+sigExt))
+	if err != nil {
+		return fmt.Errorf("%s: missing signature", name)
+	}
+	if err := pub.Verify(data, sig); err != nil {
+		return fmt.Errorf("%s: %s", name, err)
+	}
+	return nil
+}
+
+// parseChecksums reads a sha256sum-style checksums file into a map of file
+// name to hex digest
+func parseChecksums(r io.Reader) (map[string]string, error) {
+	sums := map[string]string{}
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("malformed checksum line: %q", line)
+		}
+		sums[strings.TrimPrefix(fields[1], "*")] = fields[0]
+	}
+	return sums, s.Err()
+}
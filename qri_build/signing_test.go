@@ -0,0 +1,170 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestSigningKeyRoundTrip(t *testing.T) {
+	key, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseSigningKey(key.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.ID != key.ID || !parsed.Key.Equal(key.Key) {
+		t.Error("expected the parsed secret key to match")
+	}
+
+	sig := parsed.Sign([]byte("release"), "qri_linux_amd64.zip")
+	if !strings.Contains(string(sig), "trusted comment: timestamp:") || !strings.Contains(string(sig), "\tfile:qri_linux_amd64.zip\n") {
+		t.Errorf("unexpected signature:\n%s", sig)
+	}
+	if err := key.Public().Verify([]byte("release"), sig); err != nil {
+		t.Errorf("expected signature to verify: %s", err)
+	}
+	if err := key.Public().Verify([]byte("tampered"), sig); err == nil {
+		t.Error("expected tampered data not to verify")
+	}
+
+	other, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Public().Verify([]byte("release"), sig); err == nil || !strings.Contains(err.Error(), "signed by key") {
+		t.Errorf("expected a key mismatch, got %v", err)
+	}
+}
+
+func TestLoadPublicKey(t *testing.T) {
+	key, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := key.Public()
+	path := filepath.Join(t.TempDir(), "qri_build.key.pub")
+	if err := ioutil.WriteFile(path, []byte(pub.File()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, keyOrPath := range []string{pub.String(), path} {
+		got, err := LoadPublicKey(keyOrPath)
+		if err != nil {
+			t.Errorf("%s: %s", keyOrPath, err)
+			continue
+		}
+		if got.ID != pub.ID || !got.Key.Equal(pub.Key) {
+			t.Errorf("%s: loaded the wrong key", keyOrPath)
+		}
+	}
+
+	_, err = LoadPublicKey(filepath.Join(t.TempDir(), "missing.pub"))
+	if err == nil || !strings.Contains(err.Error(), "no such file") {
+		t.Errorf("expected a missing key file to report the file error, got %v", err)
+	}
+	if _, err := LoadPublicKey(""); err == nil {
+		t.Error("expected an empty public key to fail")
+	}
+}
+
+func TestSignRelease(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"qri_linux_amd64.zip": "zip",
+		"output/qri.deb":      "deb",
+		// a qri checkout & webapp build next to a release in the working
+		// directory
+		"qri/go.mod":                 "module github.com/qri-io/qri\n",
+		"qri/.git/HEAD":              "ref: refs/heads/master\n",
+		"webapp/minified/index.html": "<html></html>\n",
+		"notes.txt":                  "not an artifact",
+	})
+	m, err := LoadManifest(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"qri_linux_amd64.zip", "output/qri.deb"} {
+		if _, err := m.Add(filepath.Join(dir, name), ArtifactArchive, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SignRelease(dir, key); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"SHA256SUMS", "SHA256SUMS.minisig", "manifest.json.minisig", "qri_linux_amd64.zip.minisig", "output/qri.deb.minisig"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("expected %s to be written: %s", name, err)
+		}
+	}
+	sums, err := ioutil.ReadFile(filepath.Join(dir, "SHA256SUMS"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(sums)), "\n"); len(lines) != 3 {
+		t.Errorf("expected 3 checksums, got:\n%s", sums)
+	}
+	for _, name := range []string{"qri/go.mod", "qri/.git/HEAD", "webapp/minified/index.html", "notes.txt"} {
+		if strings.Contains(string(sums), name) {
+			t.Errorf("expected %s not to be checksummed", name)
+		}
+		if _, err := os.Stat(filepath.Join(dir, name+".minisig")); err == nil {
+			t.Errorf("expected %s not to be signed", name)
+		}
+	}
+	if err := VerifyRelease(dir, key.Public()); err != nil {
+		t.Fatalf("expected release to verify: %s", err)
+	}
+
+	writeFiles(t, dir, map[string]string{"qri/go.mod": "module changed\n"})
+	if err := VerifyRelease(dir, key.Public()); err != nil {
+		t.Errorf("expected files outside the manifest not to affect verification: %s", err)
+	}
+	writeFiles(t, dir, map[string]string{"output/qri.deb": "tampered"})
+	if err := VerifyRelease(dir, key.Public()); err == nil {
+		t.Error("expected a tampered artifact to fail verification")
+	}
+}
+
+func TestSignReleaseNeedsManifest(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"qri_linux_amd64.zip": "zip"})
+	key, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SignRelease(dir, key); err == nil {
+		t.Error("expected a directory without manifest.json to fail")
+	}
+
+	writeFiles(t, dir, map[string]string{manifestFilename: `{"artifacts": [{"name": "../secret"}]}`})
+	if err := SignRelease(dir, key); err == nil || !strings.Contains(err.Error(), "outside the release directory") {
+		t.Errorf("expected an artifact outside the release to fail, got %v", err)
+	}
+}
+
+func TestReleaseDirRequired(t *testing.T) {
+	cmd := &cobra.Command{}
+	cmd.Flags().String("dir", "", releaseDirUsage)
+	if _, err := releaseDir(cmd); err == nil {
+		t.Error("expected a missing --dir to fail")
+	}
+	cmd.Flags().Set("dir", "output")
+	if dir, err := releaseDir(cmd); err != nil || dir != "output" {
+		t.Errorf("expected output, got %q (%v)", dir, err)
+	}
+}
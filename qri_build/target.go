@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Target is a platform to build for, written "os/arch" or, for architectures
+// with variants, "os/arch/variant" like "linux/arm/v7"
+type Target struct {
+	OS      string
+	Arch    string
+	Variant string
+}
+
+// ParseTarget parses an "os/arch[/variant]" string
+func ParseTarget(s string) (t Target, err error) {
+	parts := strings.Split(strings.TrimSpace(s), "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return t, fmt.Errorf("invalid target %q, expected os/arch or os/arch/variant", s)
+	}
+	t = Target{OS: parts[0], Arch: parts[1]}
+	if len(parts) == 3 {
+		t.Variant = parts[2]
+		if _, err := t.variantEnv(); err != nil {
+			return t, err
+		}
+	}
+	return t, nil
+}
+
+// ParseTargets parses a list of target strings
+func ParseTargets(strs []string) ([]Target, error) {
+	targets := make([]Target, 0, len(strs))
+	for _, s := range strs {
+		t, err := ParseTarget(s)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
+// String formats the target as os/arch[/variant]
+func (t Target) String() string {
+	if t.Variant != "" {
+		return fmt.Sprintf("%s/%s/%s", t.OS, t.Arch, t.Variant)
+	}
+	return fmt.Sprintf("%s/%s", t.OS, t.Arch)
+}
+
+// Name formats the target for use in file names, eg. "linux_armv7"
+func (t Target) Name() string {
+	return fmt.Sprintf("%s_%s%s", t.OS, t.Arch, t.Variant)
+}
+
+// BinName is the file name of the qri binary built for the target
+func (t Target) BinName() string {
+	if t.OS == "windows" {
+		return binName + ".exe"
+	}
+	return binName
+}
+
+// variantEnv returns the environment variable go reads the architecture
+// variant from
+func (t Target) variantEnv() (map[string]string, error) {
+	switch t.Arch {
+	case "arm":
+		switch t.Variant {
+		case "v5", "v6", "v7":
+			return map[string]string{"GOARM": strings.TrimPrefix(t.Variant, "v")}, nil
+		}
+	case "amd64":
+		switch t.Variant {
+		case "v1", "v2", "v3", "v4":
+			return map[string]string{"GOAMD64": t.Variant}, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid variant %q for architecture %s", t.Variant, t.Arch)
+}
+
+// BuildProfile configures the compiler for a target
+type BuildProfile struct {
+	// CGOEnabled turns cgo on. builds default to CGO_ENABLED=0 so that every
+	// target is built the same way regardless of the host's C toolchain
+	CGOEnabled bool `json:"cgoEnabled"`
+	// CC & CXX are the C & C++ (cross) compilers to use with cgo
+	CC  string `json:"cc"`
+	CXX string `json:"cxx"`
+	// Tags are extra build tags
+	Tags []string `json:"tags"`
+	// Ldflags are extra linker flags
+	Ldflags string `json:"ldflags"`
+}
+
+// Profile returns the configured build profile for a target. Profiles are
+// looked up by the full target string, then "os/arch", then "os", then "*".
+// targets with no configured profile get the zero value
+func (c *Config) Profile(t Target) BuildProfile {
+	keys := []string{t.String(), fmt.Sprintf("%s/%s", t.OS, t.Arch), t.OS, "*"}
+	for _, key := range keys {
+		if p, ok := c.Targets[key]; ok {
+			return p
+		}
+	}
+	return BuildProfile{}
+}
+
+// Env returns the environment variables needed to build for target t with
+// profile p
+func (p BuildProfile) Env(t Target) map[string]string {
+	env := map[string]string{
+		"GOOS":        t.OS,
+		"GOARCH":      t.Arch,
+		"CGO_ENABLED": "0",
+	}
+	if t.Variant != "" {
+		vars, _ := t.variantEnv()
+		for key, val := range vars {
+			env[key] = val
+		}
+	}
+	if p.CGOEnabled {
+		env["CGO_ENABLED"] = "1"
+	}
+	if p.CC != "" {
+		env["CC"] = p.CC
+	}
+	if p.CXX != "" {
+		env["CXX"] = p.CXX
+	}
+	return env
+}
+
+// BuildArgs returns extra arguments to go build for the profile
+func (p BuildProfile) BuildArgs() (args []string) {
+	if len(p.Tags) > 0 {
+		args = append(args, "-tags", strings.Join(p.Tags, ","))
+	}
+	if p.Ldflags != "" {
+		args = append(args, "-ldflags", p.Ldflags)
+	}
+	return args
+}
+
+// Matches reports whether t matches pattern. a pattern without a variant
+// matches every variant of its os/arch
+func (t Target) Matches(pattern Target) bool {
+	return t.OS == pattern.OS && t.Arch == pattern.Arch && (pattern.Variant == "" || t.Variant == pattern.Variant)
+}
+
+// supportedTargets lists the os/arch pairs a go toolchain can build for
+func supportedTargets(goBin string) (map[string]bool, error) {
+	output, err := command{
+		String: "%s tool dist list",
+		Tmpl:   []interface{}{goBin},
+		Env:    goEnv(environ(nil)),
+	}.SecretRunStdout()
+	if err != nil {
+		return nil, fmt.Errorf("listing supported targets: %s", err)
+	}
+	supported := map[string]bool{}
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			supported[line] = true
+		}
+	}
+	return supported, nil
+}
+
+// ValidateTargets drops duplicate & excluded targets, then checks the rest
+// against the toolchain's supported os/arch pairs. every unsupported target
+// is reported in a single error, so nothing is built until the list is fixed
+func ValidateTargets(targets, exclude []Target, goBin string) ([]Target, error) {
+	supported, err := supportedTargets(goBin)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		valid   []Target
+		invalid []string
+		seen    = map[Target]bool{}
+	)
+TARGETS:
+	for _, t := range targets {
+		if seen[t] {
+			continue
+		}
+		seen[t] = true
+		for _, pattern := range exclude {
+			if t.Matches(pattern) {
+				log.Infof("skipping excluded target %s", t)
+				continue TARGETS
+			}
+		}
+		if !supported[fmt.Sprintf("%s/%s", t.OS, t.Arch)] {
+			invalid = append(invalid, t.String())
+			continue
+		}
+		valid = append(valid, t)
+	}
+
+	if len(invalid) > 0 {
+		return nil, fmt.Errorf("unsupported targets: %s. see 'go tool dist list' for valid os/arch pairs, or skip them with --exclude", strings.Join(invalid, ", "))
+	}
+	if len(valid) == 0 {
+		return nil, fmt.Errorf("no targets to build")
+	}
+	return valid, nil
+}
+
+// crossTargets expands lists of platforms & architectures to every
+// combination of the two
+func crossTargets(platforms, arches []string) []Target {
+	targets := make([]Target, 0, len(platforms)*len(arches))
+	for _, platform := range platforms {
+		for _, arch := range arches {
+			targets = append(targets, Target{OS: platform, Arch: arch})
+		}
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].String() < targets[j].String()
+	})
+	return targets
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestParseTarget(t *testing.T) {
+	cases := []struct {
+		input  string
+		expect Target
+		env    map[string]string
+		err    string
+	}{
+		{"linux/amd64", Target{OS: "linux", Arch: "amd64"}, nil, ""},
+		{" darwin/arm64 ", Target{OS: "darwin", Arch: "arm64"}, nil, ""},
+		{"linux/arm/v7", Target{OS: "linux", Arch: "arm", Variant: "v7"}, map[string]string{"GOARM": "7"}, ""},
+		{"linux/arm/v5", Target{OS: "linux", Arch: "arm", Variant: "v5"}, map[string]string{"GOARM": "5"}, ""},
+		{"linux/amd64/v3", Target{OS: "linux", Arch: "amd64", Variant: "v3"}, map[string]string{"GOAMD64": "v3"}, ""},
+
+		{"", Target{}, nil, "expected os/arch"},
+		{"linux", Target{}, nil, "expected os/arch"},
+		{"linux/", Target{}, nil, "expected os/arch"},
+		{"/amd64", Target{}, nil, "expected os/arch"},
+		{"linux/arm/v7/extra", Target{}, nil, "expected os/arch"},
+		{"linux/arm/v8", Target{}, nil, `invalid variant "v8" for architecture arm`},
+		{"linux/amd64/v5", Target{}, nil, `invalid variant "v5" for architecture amd64`},
+		{"linux/arm64/v8", Target{}, nil, "invalid variant"},
+	}
+	for _, c := range cases {
+		got, err := ParseTarget(c.input)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%q: expected error containing %q, got %v", c.input, c.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", c.input, err)
+			continue
+		}
+		if got != c.expect {
+			t.Errorf("%q: expected %+v, got %+v", c.input, c.expect, got)
+		}
+
+		env := BuildProfile{}.Env(got)
+		for _, key := range []string{"GOARM", "GOAMD64"} {
+			if env[key] != c.env[key] {
+				t.Errorf("%q: expected %s=%q, got %q", c.input, key, c.env[key], env[key])
+			}
+		}
+	}
+}
+
+func TestParseTargets(t *testing.T) {
+	got, err := ParseTargets([]string{"linux/amd64", "windows/386", "linux/arm/v6"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []Target{{OS: "linux", Arch: "amd64"}, {OS: "windows", Arch: "386"}, {OS: "linux", Arch: "arm", Variant: "v6"}}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+	if _, err := ParseTargets([]string{"linux/amd64", "bad"}); err == nil {
+		t.Error("expected a bad target to fail the list")
+	}
+}
+
+func TestTargetNames(t *testing.T) {
+	cases := []struct {
+		target                Target
+		str, name, binaryName string
+	}{
+		{Target{OS: "linux", Arch: "amd64"}, "linux/amd64", "linux_amd64", "qri"},
+		{Target{OS: "linux", Arch: "arm", Variant: "v7"}, "linux/arm/v7", "linux_armv7", "qri"},
+		{Target{OS: "windows", Arch: "amd64"}, "windows/amd64", "windows_amd64", "qri.exe"},
+	}
+	for _, c := range cases {
+		if c.target.String() != c.str || c.target.Name() != c.name || c.target.BinName() != c.binaryName {
+			t.Errorf("expected %s %s %s, got %s %s %s", c.str, c.name, c.binaryName, c.target.String(), c.target.Name(), c.target.BinName())
+		}
+	}
+}
+
+func TestConfigProfile(t *testing.T) {
+	config := &Config{Targets: map[string]BuildProfile{
+		"linux/arm/v7": {CC: "arm-linux-gnueabihf-gcc"},
+		"linux/arm":    {CC: "arm-linux-gnueabi-gcc"},
+		"linux":        {Tags: []string{"linux"}},
+		"*":            {Ldflags: "-s -w"},
+	}}
+	cases := []struct {
+		target Target
+		expect BuildProfile
+	}{
+		{Target{OS: "linux", Arch: "arm", Variant: "v7"}, BuildProfile{CC: "arm-linux-gnueabihf-gcc"}},
+		{Target{OS: "linux", Arch: "arm", Variant: "v6"}, BuildProfile{CC: "arm-linux-gnueabi-gcc"}},
+		{Target{OS: "linux", Arch: "arm"}, BuildProfile{CC: "arm-linux-gnueabi-gcc"}},
+		{Target{OS: "linux", Arch: "amd64"}, BuildProfile{Tags: []string{"linux"}}},
+		{Target{OS: "darwin", Arch: "arm64"}, BuildProfile{Ldflags: "-s -w"}},
+	}
+	for _, c := range cases {
+		if got := config.Profile(c.target); !reflect.DeepEqual(got, c.expect) {
+			t.Errorf("%s: expected %+v, got %+v", c.target, c.expect, got)
+		}
+	}
+
+	delete(config.Targets, "*")
+	if got := config.Profile(Target{OS: "darwin", Arch: "arm64"}); !reflect.DeepEqual(got, BuildProfile{}) {
+		t.Errorf("expected the zero profile without a match, got %+v", got)
+	}
+}
+
+func TestBuildProfileEnv(t *testing.T) {
+	cases := []struct {
+		description string
+		profile     BuildProfile
+		target      Target
+		expect      map[string]string
+	}{
+		{"default", BuildProfile{}, Target{OS: "linux", Arch: "amd64"},
+			map[string]string{"GOOS": "linux", "GOARCH": "amd64", "CGO_ENABLED": "0"}},
+		{"cgo", BuildProfile{CGOEnabled: true}, Target{OS: "darwin", Arch: "arm64"},
+			map[string]string{"GOOS": "darwin", "GOARCH": "arm64", "CGO_ENABLED": "1"}},
+		{"cross compiler", BuildProfile{CGOEnabled: true, CC: "arm-linux-gnueabihf-gcc", CXX: "arm-linux-gnueabihf-g++"}, Target{OS: "linux", Arch: "arm", Variant: "v7"},
+			map[string]string{"GOOS": "linux", "GOARCH": "arm", "GOARM": "7", "CGO_ENABLED": "1", "CC": "arm-linux-gnueabihf-gcc", "CXX": "arm-linux-gnueabihf-g++"}},
+		// compilers are only used with cgo, but are passed through regardless
+		{"compiler without cgo", BuildProfile{CC: "x86_64-w64-mingw32-gcc"}, Target{OS: "windows", Arch: "amd64", Variant: "v2"},
+			map[string]string{"GOOS": "windows", "GOARCH": "amd64", "GOAMD64": "v2", "CGO_ENABLED": "0", "CC": "x86_64-w64-mingw32-gcc"}},
+	}
+	for _, c := range cases {
+		if got := c.profile.Env(c.target); !reflect.DeepEqual(got, c.expect) {
+			t.Errorf("%s: expected %v, got %v", c.description, c.expect, got)
+		}
+	}
+
+	args := BuildProfile{Tags: []string{"netgo", "osusergo"}, Ldflags: "-s -w"}.BuildArgs()
+	if expect := []string{"-tags", "netgo,osusergo", "-ldflags", "-s -w"}; !reflect.DeepEqual(args, expect) {
+		t.Errorf("expected build args %v, got %v", expect, args)
+	}
+	if args := (BuildProfile{}).BuildArgs(); len(args) != 0 {
+		t.Errorf("expected no build args, got %v", args)
+	}
+}
+
+func TestCrossTargets(t *testing.T) {
+	got := crossTargets([]string{"windows", "darwin"}, []string{"amd64", "386"})
+	expect := []Target{
+		{OS: "darwin", Arch: "386"},
+		{OS: "darwin", Arch: "amd64"},
+		{OS: "windows", Arch: "386"},
+		{OS: "windows", Arch: "amd64"},
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+	if got := crossTargets(nil, []string{"amd64"}); len(got) != 0 {
+		t.Errorf("expected no targets, got %v", got)
+	}
+}
+
+// newQriTargetsCmd creates a command with the qri command's target flags
+func newQriTargetsCmd(args ...string) *cobra.Command {
+	cmd := &cobra.Command{}
+	cmd.Flags().StringSlice("targets", nil, "")
+	cmd.Flags().StringSlice("platforms", []string{"linux"}, "")
+	cmd.Flags().StringSlice("arches", []string{"amd64"}, "")
+	cmd.Flags().Parse(args)
+	return cmd
+}
+
+func TestQriTargets(t *testing.T) {
+	cases := []struct {
+		args   []string
+		expect []Target
+		err    string
+	}{
+		{nil, []Target{{OS: "linux", Arch: "amd64"}}, ""},
+		{[]string{"--platforms", "darwin,linux", "--arches", "arm64"}, []Target{{OS: "darwin", Arch: "arm64"}, {OS: "linux", Arch: "arm64"}}, ""},
+		{[]string{"--targets", "linux/arm/v7"}, []Target{{OS: "linux", Arch: "arm", Variant: "v7"}}, ""},
+		{[]string{"--targets", "linux/arm/v7", "--platforms", "darwin"}, nil, "can't be combined"},
+		{[]string{"--targets", "linux/arm/v7", "--arches", "amd64"}, nil, "can't be combined"},
+		{[]string{"--targets", "linux"}, nil, "invalid target"},
+	}
+	for _, c := range cases {
+		got, err := qriTargets(newQriTargetsCmd(c.args...))
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%v: expected error containing %q, got %v", c.args, c.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %s", c.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.expect) {
+			t.Errorf("%v: expected %v, got %v", c.args, c.expect, got)
+		}
+	}
+}
+
+// fakeDistList responds to 'go tool dist list' with a short list of
+// supported os/arch pairs
+var fakeDistList = map[string]FakeResponse{
+	"go env GOVERSION":  {Stdout: "go1.22.0\n"},
+	"go tool dist list": {Stdout: "darwin/amd64\ndarwin/arm64\nlinux/amd64\nlinux/arm\nwindows/amd64\n"},
+}
+
+func TestValidateTargets(t *testing.T) {
+	mustParse := func(strs ...string) []Target {
+		targets, err := ParseTargets(strs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return targets
+	}
+	cases := []struct {
+		description      string
+		targets, exclude []Target
+		expect           []Target
+		err              string
+	}{
+		{"all supported",
+			mustParse("linux/amd64", "darwin/arm64", "linux/amd64"), nil,
+			mustParse("linux/amd64", "darwin/arm64"), ""},
+		{"exclude patterns drop matches",
+			mustParse("linux/amd64", "linux/arm/v6", "linux/arm/v7", "darwin/amd64", "windows/amd64"), mustParse("linux/arm", "windows/amd64"),
+			mustParse("linux/amd64", "darwin/amd64"), ""},
+		{"exclude a single variant",
+			mustParse("linux/arm/v6", "linux/arm/v7"), mustParse("linux/arm/v6"),
+			mustParse("linux/arm/v7"), ""},
+		{"excluded targets aren't validated",
+			mustParse("linux/amd64", "plan9/mips"), mustParse("plan9/mips"),
+			mustParse("linux/amd64"), ""},
+		{"every invalid target is reported",
+			mustParse("plan9/mips", "linux/amd64", "linux/fake", "js/wasm"), nil,
+			nil, "unsupported targets: plan9/mips, linux/fake, js/wasm."},
+		{"fully excluded",
+			mustParse("linux/amd64", "linux/arm/v7"), mustParse("linux/amd64", "linux/arm"),
+			nil, "no targets to build"},
+		{"no targets", nil, nil, nil, "no targets to build"},
+	}
+	for _, c := range cases {
+		fake := &RecordingExecutor{Responses: fakeDistList}
+		useExecutor(t, fake)
+		got, err := ValidateTargets(c.targets, c.exclude, "go")
+		if lines := fake.Lines(); len(lines) != 1 || lines[0] != "go tool dist list" {
+			t.Errorf("%s: expected the toolchain to be asked for its targets, got %q", c.description, lines)
+		}
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%s: expected error containing %q, got %v", c.description, c.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", c.description, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.expect) {
+			t.Errorf("%s: expected %v, got %v", c.description, c.expect, got)
+		}
+	}
+
+	useExecutor(t, &RecordingExecutor{Responses: map[string]FakeResponse{"go tool dist list": {Err: ExitError(2)}}})
+	if _, err := ValidateTargets(mustParse("linux/amd64"), nil, "go"); err == nil || !strings.Contains(err.Error(), "listing supported targets") {
+		t.Errorf("expected a failed listing to fail validation, got %v", err)
+	}
+}
+
+func TestResolveTargets(t *testing.T) {
+	repo := fakeQriRepo(t)
+	fake := &RecordingExecutor{Responses: fakeDistList}
+	useExecutor(t, fake)
+
+	targets := []Target{{OS: "linux", Arch: "amd64"}, {OS: "linux", Arch: "arm", Variant: "v7"}, {OS: "windows", Arch: "amd64"}}
+	got, err := resolveTargets(targets, []string{"windows/amd64", "linux/arm/v7"}, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expect := []Target{{OS: "linux", Arch: "amd64"}}; !reflect.DeepEqual(got, expect) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+	if lines := fake.Lines(); len(lines) != 2 || lines[0] != "go env GOVERSION" || lines[1] != "go tool dist list" {
+		t.Errorf("expected the repo's toolchain to list targets, got %q", lines)
+	}
+
+	if _, err := resolveTargets(targets, []string{"windows"}, repo); err == nil || !strings.Contains(err.Error(), "invalid target") {
+		t.Errorf("expected a bad exclude pattern to fail, got %v", err)
+	}
+	if _, err := resolveTargets(targets, []string{"linux/amd64", "linux/arm", "windows/amd64"}, repo); err == nil || !strings.Contains(err.Error(), "no targets to build") {
+		t.Errorf("expected excluding everything to fail, got %v", err)
+	}
+}
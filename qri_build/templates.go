@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+)
+
+// embeddedTemplates are the default archive templates, used when --templates
+// isn't set
+//
+//go:embed templates
+var embeddedTemplates embed.FS
+
+// knownOS lists GOOS values. top level directories in an archive template
+// tree named for an OS only apply to targets for that OS
+var knownOS = map[string]bool{
+	"aix": true, "android": true, "darwin": true, "dragonfly": true,
+	"freebsd": true, "illumos": true, "ios": true, "js": true, "linux": true,
+	"netbsd": true, "openbsd": true, "plan9": true, "solaris": true,
+	"wasip1": true, "windows": true,
+}
+
+// ArchiveData is passed to archive templates
+type ArchiveData struct {
+	// Version is the qri version being released
+	Version string
+	// Commit is the qri repo's abbreviated HEAD commit hash, if known
+	Commit string
+	// Date is when the release was built
+	Date    time.Time
+	Target  Target
+	BinName string
+	// Platform & Arch are the target's OS & architecture
+	Platform string
+	Arch     string
+}
+
+// ArchiveTemplates renders the files added to each qri archive from the "qri"
+// directory of a template tree. Files ending in ".tmpl" are executed as
+// text/templates with ArchiveData & have the extension removed, everything
+// else is copied as-is
+type ArchiveTemplates struct {
+	Version string
+	Commit  string
+	Date    time.Time
+	// Docs are added to every non-windows archive when set
+	Docs *CLIDocs
+
+	fsys fs.FS
+}
+
+// LoadArchiveTemplates reads templates from dir, falling back to the
+// templates built into qri_build when dir is empty. Version & commit data
+// come from the qri repo
+func LoadArchiveTemplates(dir, qriRepoPath string) (*ArchiveTemplates, error) {
+	root, err := fs.Sub(embeddedTemplates, "templates")
+	if err != nil {
+		return nil, err
+	}
+	if dir != "" {
+		if _, err := os.Stat(dir); err != nil {
+			return nil, fmt.Errorf("reading templates: %s", err)
+		}
+		root = os.DirFS(dir)
+	}
+	fsys, err := fs.Sub(root, "qri")
+	if err != nil {
+		return nil, err
+	}
+
+	a := &ArchiveTemplates{Date: time.Now().UTC(), fsys: fsys}
+	if a.Version, err = QriVersion(qriRepoPath); err != nil {
+		log.Warnf("reading qri version: %s", err)
+	}
+	if _, err := os.Stat(filepath.Join(qriRepoPath, ".git")); err == nil {
+		commit, err := command{String: "git rev-parse --short HEAD", Dir: qriRepoPath}.SecretRunStdout()
+		if err != nil {
+			log.Warnf("reading qri commit: %s", err)
+		}
+		a.Commit = strings.TrimSpace(commit)
+	}
+	return a, nil
+}
+
+// Data returns template data for a target
+func (a *ArchiveTemplates) Data(target Target) ArchiveData {
+	return ArchiveData{
+		Version:  a.Version,
+		Commit:   a.Commit,
+		Date:     a.Date,
+		Target:   target,
+		BinName:  target.BinName(),
+		Platform: target.OS,
+		Arch:     target.Arch,
+	}
+}
+
+// Files renders the files for a target's archive. Paths are slash-separated
+// & relative to the archive root. Files in an OS directory replace shared
+// files with the same name. windows archives get README.txt in place of
+// readme.md, and text files with windows line endings
+func (a *ArchiveTemplates) Files(target Target) ([]packageFile, error) {
+	sources := map[string]string{}
+	overlays := map[string]string{}
+	err := fs.WalkDir(a.fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if dir := strings.SplitN(p, "/", 2); len(dir) == 2 && knownOS[dir[0]] {
+			if dir[0] == target.OS {
+				overlays[dir[1]] = p
+			}
+			return nil
+		}
+		sources[p] = p
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading templates: %s", err)
+	}
+	for name, p := range overlays {
+		sources[name] = p
+	}
+
+	// parse every template together so they can include one another
+	tmpl := template.New("")
+	for name, p := range sources {
+		if !strings.HasSuffix(name, ".tmpl") {
+			continue
+		}
+		data, err := fs.ReadFile(a.fsys, p)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tmpl.New(name).Parse(string(data)); err != nil {
+			return nil, fmt.Errorf("parsing template %s: %s", p, err)
+		}
+	}
+
+	files := make([]packageFile, 0, len(sources))
+	for name, p := range sources {
+		fi, err := fs.Stat(a.fsys, p)
+		if err != nil {
+			return nil, err
+		}
+		f := packageFile{Path: name, Mode: 0644}
+		if fi.Mode()&0111 != 0 {
+			f.Mode = 0755
+		}
+
+		if strings.HasSuffix(name, ".tmpl") {
+			buf := &bytes.Buffer{}
+			if err := tmpl.ExecuteTemplate(buf, name, a.Data(target)); err != nil {
+				return nil, fmt.Errorf("executing template %s: %s", p, err)
+			}
+			f.Path = strings.TrimSuffix(name, ".tmpl")
+			f.Data = buf.Bytes()
+		} else if f.Data, err = fs.ReadFile(a.fsys, p); err != nil {
+			return nil, err
+		}
+
+		if target.OS == "windows" {
+			// windows users get a readme notepad can open
+			if f.Path == "readme.md" {
+				f.Path = "README.txt"
+			}
+			if isText(f.Data) {
+				f.Data = crlf(f.Data)
+			}
+		}
+		files = append(files, f)
+	}
+
+	if a.Docs != nil && target.OS != "windows" {
+		files = append(files, a.Docs.ArchiveFiles()...)
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files, nil
+}
+
+// File renders a single archive file by its path in the archive
+func (a *ArchiveTemplates) File(target Target, name string) ([]byte, error) {
+	files, err := a.Files(target)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if f.Path == name {
+			return f.Data, nil
+		}
+	}
+	return nil, fmt.Errorf("no %s template", name)
+}
+
+// isText reports whether data looks like text rather than a binary file
+func isText(data []byte) bool {
+	return utf8.Valid(data) && !bytes.Contains(data, []byte{0})
+}
+
+// crlf converts line endings to CRLF. it works on whole files: existing CRLF
+// endings are normalized first so they aren't doubled
+func crlf(data []byte) []byte {
+	data = bytes.Replace(data, []byte("\r\n"), []byte("\n"), -1)
+	return bytes.Replace(data, []byte("\n"), []byte("\r\n"), -1)
+}
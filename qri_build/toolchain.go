@@ -0,0 +1,265 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/blang/semver"
+)
+
+// ToolchainCache keeps go toolchains in a local directory, fetching release
+// archives from a mirror when a version is missing
+type ToolchainCache struct {
+	Dir       string
+	MirrorURL string
+}
+
+// toolchainLock serializes toolchain downloads, builds for different targets
+// run concurrently & usually want the same toolchain
+var toolchainLock sync.Mutex
+
+// newToolchainCache creates a cache from configuration, filling in defaults
+func newToolchainCache(c ToolchainConfig) (*ToolchainCache, error) {
+	dir := c.CacheDir
+	if dir == "" {
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(cacheDir, "qri_build", "toolchains")
+	}
+	mirror := c.MirrorURL
+	if mirror == "" {
+		mirror = DefaultGoMirrorURL
+	}
+	return &ToolchainCache{Dir: dir, MirrorURL: strings.TrimSuffix(mirror, "/")}, nil
+}
+
+// goReleaseName formats a version the way go names its releases. Before go
+// 1.21 the first release of a minor version had no patch number
+func goReleaseName(v semver.Version) string {
+	name := fmt.Sprintf("go%d.%d", v.Major, v.Minor)
+	if len(v.Pre) > 0 {
+		pre := make([]string, len(v.Pre))
+		for i, p := range v.Pre {
+			pre[i] = p.String()
+		}
+		return name + strings.Join(pre, "")
+	}
+	if v.Patch > 0 || v.Major > 1 || v.Minor >= 21 {
+		name += fmt.Sprintf(".%d", v.Patch)
+	}
+	return name
+}
+
+// GoBin returns the path to the go binary of the requested version for the
+// host platform, downloading the toolchain if it isn't cached
+func (tc *ToolchainCache) GoBin(v semver.Version) (string, error) {
+	toolchainLock.Lock()
+	defer toolchainLock.Unlock()
+
+	release := goReleaseName(v)
+	root := filepath.Join(tc.Dir, release)
+	bin := filepath.Join(root, "go", "bin", "go")
+	if runtime.GOOS == "windows" {
+		bin += ".exe"
+	}
+
+	if _, err := os.Stat(bin); err == nil {
+		return bin, nil
+	}
+
+	log.Infof("downloading go toolchain %s", release)
+	if err := tc.download(release, root); err != nil {
+		return "", fmt.Errorf("downloading go toolchain %s: %s", release, err)
+	}
+	return bin, nil
+}
+
+// download fetches & unpacks a go release archive into dest
+func (tc *ToolchainCache) download(release, dest string) error {
+	ext := ".tar.gz"
+	if runtime.GOOS == "windows" {
+		ext = ".zip"
+	}
+	archiveName := fmt.Sprintf("%s.%s-%s%s", release, runtime.GOOS, runtime.GOARCH, ext)
+	url := fmt.Sprintf("%s/%s", tc.MirrorURL, archiveName)
+
+	if err := os.MkdirAll(tc.Dir, 0755); err != nil {
+		return err
+	}
+	archive, err := ioutil.TempFile(tc.Dir, archiveName)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+
+	log.Infof("fetching %s", url)
+	res, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching %s: %s", url, res.Status)
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(archive, h), res.Body); err != nil {
+		return err
+	}
+	if err := tc.verify(url, fmt.Sprintf("%x", h.Sum(nil))); err != nil {
+		return err
+	}
+
+	// unpack into a temp dir & rename so an interrupted download never leaves
+	// a half-populated toolchain behind
+	tmp, err := ioutil.TempDir(tc.Dir, release)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	if ext == ".zip" {
+		err = unzip(archive.Name(), tmp)
+	} else {
+		err = untargz(archive.Name(), tmp)
+	}
+	if err != nil {
+		return err
+	}
+
+	os.RemoveAll(dest)
+	return os.Rename(tmp, dest)
+}
+
+// verify checks an archive digest against the ".sha256" file published next
+// to it. mirrors must publish checksums, unverified toolchains are never
+// installed
+func (tc *ToolchainCache) verify(url, digest string) error {
+	res, err := http.Get(url + ".sha256")
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching checksum for %s: %s", url, res.Status)
+	}
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 || fields[0] != digest {
+		return fmt.Errorf("checksum mismatch for %s", url)
+	}
+	return nil
+}
+
+// untargz unpacks a gzipped tarball into dir
+func untargz(path, dir string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gzr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gzr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		target, err := archivePath(dir, hdr.Name)
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := writeFile(target, tr, os.FileMode(hdr.Mode)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// unzip unpacks a zip archive into dir
+func unzip(path, dir string) error {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		target, err := archivePath(dir, f.Name)
+		if err != nil {
+			return err
+		}
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = writeFile(target, r, f.Mode())
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// archivePath joins an archive entry name to dir, refusing entries that
+// would escape it
+func archivePath(dir, name string) (string, error) {
+	target := filepath.Join(dir, name)
+	if !strings.HasPrefix(target, filepath.Clean(dir)+string(os.PathSeparator)) {
+		return "", fmt.Errorf("invalid archive entry: %s", name)
+	}
+	return target, nil
+}
+
+func writeFile(path string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/blang/semver"
+)
+
+// fakeGoArchive is a go release tarball holding a go binary that prints its
+// version
+func fakeGoArchive(t *testing.T, version string) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	gzw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gzw)
+	script := []byte("#!/bin/sh\necho " + version + "\n")
+	for _, hdr := range []*tar.Header{
+		{Name: "go/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "go/bin/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "go/bin/go", Typeflag: tar.TypeReg, Mode: 0755, Size: int64(len(script))},
+	} {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := tw.Write(script); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// toolchainMirror serves go release archives & their checksums, counting
+// requests
+type toolchainMirror struct {
+	Files map[string][]byte
+
+	lk       sync.Mutex
+	requests []string
+}
+
+func (m *toolchainMirror) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.lk.Lock()
+	m.requests = append(m.requests, r.URL.Path)
+	m.lk.Unlock()
+	data, ok := m.Files[strings.TrimPrefix(r.URL.Path, "/")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Write(data)
+}
+
+func (m *toolchainMirror) Requests() int {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+	return len(m.requests)
+}
+
+func TestToolchainCacheGoBin(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("mirror serves tarballs, windows toolchains are zips")
+	}
+	archive := fakeGoArchive(t, "go1.21.3")
+	name := fmt.Sprintf("go1.21.3.%s-%s.tar.gz", runtime.GOOS, runtime.GOARCH)
+	sum := fmt.Sprintf("%x", sha256.Sum256(archive))
+
+	cases := []struct {
+		description string
+		files       map[string][]byte
+		err         string
+	}{
+		{"verified", map[string][]byte{name: archive, name + ".sha256": []byte(sum + "\n")}, ""},
+		{"checksum mismatch", map[string][]byte{name: archive, name + ".sha256": []byte(strings.Repeat("0", 64))}, "checksum mismatch"},
+		{"missing checksum", map[string][]byte{name: archive}, "404 Not Found"},
+		{"missing archive", map[string][]byte{}, "404 Not Found"},
+	}
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			mirror := &toolchainMirror{Files: c.files}
+			s := httptest.NewServer(mirror)
+			defer s.Close()
+			tc, err := newToolchainCache(ToolchainConfig{CacheDir: t.TempDir(), MirrorURL: s.URL + "/"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			bin, err := tc.GoBin(semver.MustParse("1.21.3"))
+			if c.err != "" {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Fatalf("expected error containing %q, got %v", c.err, err)
+				}
+				if _, err := os.Stat(filepath.Join(tc.Dir, "go1.21.3")); !os.IsNotExist(err) {
+					t.Errorf("expected no toolchain to be installed, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bin != filepath.Join(tc.Dir, "go1.21.3", "go", "bin", "go") {
+				t.Errorf("unexpected go binary path %s", bin)
+			}
+			out, err := command{String: bin}.SecretRunStdout()
+			if err != nil || out != "go1.21.3\n" {
+				t.Errorf("expected the unpacked go binary to run, got %q (%v)", out, err)
+			}
+
+			requests := mirror.Requests()
+			if _, err := tc.GoBin(semver.MustParse("1.21.3")); err != nil {
+				t.Fatal(err)
+			}
+			if mirror.Requests() != requests {
+				t.Error("expected a cached toolchain not to be downloaded again")
+			}
+		})
+	}
+}
+
+func TestGoReleaseName(t *testing.T) {
+	cases := map[string]string{
+		"1.16.0":     "go1.16",
+		"1.16.15":    "go1.16.15",
+		"1.21.0":     "go1.21.0",
+		"1.22.0-rc1": "go1.22rc1",
+	}
+	for in, expect := range cases {
+		if got := goReleaseName(semver.MustParse(in)); got != expect {
+			t.Errorf("%s: expected %s, got %s", in, expect, got)
+		}
+	}
+}
+
+func TestGoEnvManaged(t *testing.T) {
+	useExecutor(t, executor)
+	env := map[string]string{"GOROOT": "/usr/lib/go", "GOPATH": "/go"}
+
+	if got := goEnv(copyEnv(env)); got["GOROOT"] != "/usr/lib/go" || got["GOTOOLCHAIN"] != "" {
+		t.Errorf("expected the go on $PATH to keep its environment, got %v", got)
+	}
+
+	cfg.Toolchain.Managed = true
+	got := goEnv(copyEnv(env))
+	if _, ok := got["GOROOT"]; ok {
+		t.Errorf("expected GOROOT to be unset for a managed toolchain, got %v", got)
+	}
+	if got["GOTOOLCHAIN"] != "local" || got["GOPATH"] != "/go" {
+		t.Errorf("unexpected managed environment %v", got)
+	}
+}
+
+func copyEnv(env map[string]string) map[string]string {
+	cp := map[string]string{}
+	for k, v := range env {
+		cp[k] = v
+	}
+	return cp
+}
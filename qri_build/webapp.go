@@ -0,0 +1,438 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// WebappCmd builds the qri webapp
+var WebappCmd = &cobra.Command{
+	Use:   "webapp",
+	Short: "build the qri webapp",
+	Long: `
+webapp builds the frontend repo's webapp with yarn & copies each requested variant
+to a directory under --out, ready to publish with 'qri_build ipfs publish' or embed
+in the qri binary.
+
+Variants are "minified" (the app served at app.qri.io & /ipns/webapp) and
+"unminified", for debugging. The package.json script that builds each variant
+is set in the "webapp" section of the --config file.
+
+Each variant is content-addressed: webapp.json in --out records its sha256 tree
+hash, and its IPFS CID when the IPFS node at --api is reachable. The CID is only
+calculated, nothing is added to the node.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		frontendPath, err := cmd.Flags().GetString("frontend")
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		variants, err := cmd.Flags().GetStringSlice("variants")
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		outDir, err := cmd.Flags().GetString("out")
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		apiAddr, err := cmd.Flags().GetString("api")
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		if apiAddr == "" {
+			apiAddr = cfg.IPFS.API
+		}
+		client, err := NewIPFSClient(apiAddr)
+		if err != nil {
+			log.Error(err)
+			return
+		}
+
+		manifest, err := BuildWebapp(frontendPath, outDir, variants, client)
+		if err != nil {
+			log.Errorf("building webapp: %s", err)
+			return
+		}
+		for _, b := range manifest.Bundles {
+			fmt.Printf("webapp %s %s: %s (cid: %s)\n", manifest.Version, b.Variant, filepath.Join(outDir, b.Dir), b.CID)
+		}
+	},
+}
+
+func init() {
+	WebappCmd.Flags().String("frontend", "", "path to qri frontend repo")
+	WebappCmd.Flags().StringSlice("variants", []string{"minified"}, "webapp variants to build (minified|unminified)")
+	WebappCmd.Flags().String("out", "webapp", "directory to write webapp bundles to")
+	WebappCmd.Flags().String("api", "", "multiaddr of the IPFS node's HTTP API, used to calculate CIDs")
+}
+
+// WebappConfig configures webapp builds
+type WebappConfig struct {
+	// Scripts maps webapp variants to the frontend package.json script that
+	// builds them
+	Scripts map[string]string `json:"scripts"`
+	// BuildDir is the directory frontend builds write to, relative to the
+	// frontend repo
+	BuildDir string `json:"buildDir"`
+	// PathVar is the qri variable set to the /ipfs/ path of an embedded
+	// webapp with -ldflags -X, as "import/path.Name"
+	PathVar string `json:"pathVar"`
+	// EmbedDir is the qri repo package an embedded webapp bundle is copied
+	// into, relative to the qri repo
+	EmbedDir string `json:"embedDir"`
+}
+
+// webappManifestFilename is the name of the webapp bundle manifest
+const webappManifestFilename = "webapp.json"
+
+// WebappManifest lists built webapp bundles
+type WebappManifest struct {
+	// Version is the frontend package version
+	Version string          `json:"version"`
+	Created time.Time       `json:"created"`
+	Bundles []*WebappBundle `json:"bundles"`
+}
+
+// WebappBundle is one built webapp variant
+type WebappBundle struct {
+	Variant string `json:"variant"`
+	// Dir is the bundle's directory, relative to the manifest
+	Dir string `json:"dir"`
+	// CID is the IPFS CID of Dir. empty when no IPFS node was available
+	CID string `json:"cid,omitempty"`
+	// SHA256 is a hash of the bundle's file paths & contents
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+	Files  int    `json:"files"`
+}
+
+// Bundle returns the bundle for a variant, or nil
+func (m *WebappManifest) Bundle(variant string) *WebappBundle {
+	for _, b := range m.Bundles {
+		if b.Variant == variant {
+			return b
+		}
+	}
+	return nil
+}
+
+// LoadWebappManifest reads webapp.json from a webapp output directory
+func LoadWebappManifest(dir string) (*WebappManifest, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, webappManifestFilename))
+	if err != nil {
+		return nil, err
+	}
+	m := &WebappManifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("parsing %s: %s", webappManifestFilename, err)
+	}
+	return m, nil
+}
+
+// BuildWebapp builds webapp variants from the frontend repo into outDir. client
+// calculates bundle CIDs, & may be nil
+func BuildWebapp(frontendPath, outDir string, variants []string, client *IPFSClient) (*WebappManifest, error) {
+	if frontendPath == "" {
+		return nil, fmt.Errorf("flag --frontend is required")
+	}
+	if _, err := os.Stat(frontendPath); os.IsNotExist(err) {
+		return nil, fmt.Errorf("Directory \"%s\" does not exist", frontendPath)
+	}
+	for _, v := range variants {
+		if cfg.Webapp.Scripts[v] == "" {
+			return nil, fmt.Errorf("no build script configured for webapp variant %q", v)
+		}
+	}
+
+	pkg, err := readPackageJSON(frontendPath)
+	if err != nil {
+		return nil, err
+	}
+
+	path, err := npmDoPath(frontendPath)
+	if err != nil {
+		return nil, err
+	}
+	env := environ(map[string]string{"PATH": path})
+
+	log.Infof("installing frontend dependencies...")
+	install := command{
+		String: "yarn",
+		Dir:    frontendPath,
+		Env:    env,
+		Retry:  yarnRetryPolicy,
+	}
+	if err := install.Run(); err != nil {
+		return nil, err
+	}
+
+	m := &WebappManifest{Version: pkg.Version, Created: time.Now().UTC()}
+	buildDir := filepath.Join(frontendPath, cfg.Webapp.BuildDir)
+	for _, variant := range variants {
+		log.Infof("building %s webapp...", variant)
+		if err := os.RemoveAll(buildDir); err != nil {
+			return nil, err
+		}
+		build := command{
+			String: "yarn run",
+			Args:   []string{cfg.Webapp.Scripts[variant]},
+			Dir:    frontendPath,
+			Env:    env,
+		}
+		if err := build.Run(); err != nil {
+			return nil, err
+		}
+
+		dest := filepath.Join(outDir, variant)
+		if err := os.RemoveAll(dest); err != nil {
+			return nil, err
+		}
+		if err := copyDir(buildDir, dest); err != nil {
+			return nil, fmt.Errorf("copying %s webapp: %s", variant, err)
+		}
+
+		b := &WebappBundle{Variant: variant, Dir: variant}
+		if b.SHA256, b.Size, b.Files, err = treeHash(dest); err != nil {
+			return nil, err
+		}
+		if client != nil {
+			if b.CID, err = client.Hash(dest); err != nil {
+				log.Warnf("calculating %s webapp CID: %s", variant, err)
+			}
+		}
+		m.Bundles = append(m.Bundles, b)
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return m, ioutil.WriteFile(filepath.Join(outDir, webappManifestFilename), append(data, '\n'), 0644)
+}
+
+// packageJSON holds the fields qri_build reads from package.json
+type packageJSON struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+func readPackageJSON(dir string) (*packageJSON, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "package.json"))
+	if err != nil {
+		return nil, err
+	}
+	pkg := &packageJSON{}
+	if err = json.Unmarshal(data, pkg); err != nil {
+		return nil, fmt.Errorf("reading %s package.json: %s", filepath.Base(dir), err)
+	}
+	return pkg, nil
+}
+
+// copyDir recursively copies the contents of src to dst
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if fi.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		return CopyFile(path, target)
+	})
+}
+
+// treeHash hashes the sorted relative paths & sha256 sums of every file in a
+// directory, so identical trees hash the same wherever they live
+func treeHash(dir string) (sum string, size int64, files int, err error) {
+	var lines []string
+	err = filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return err
+		}
+		lines = append(lines, fmt.Sprintf("%x  %s\n", h.Sum(nil), filepath.ToSlash(rel)))
+		size += fi.Size()
+		return nil
+	})
+	if err != nil {
+		return "", 0, 0, err
+	}
+
+	sort.Strings(lines)
+	h := sha256.New()
+	for _, line := range lines {
+		io.WriteString(h, line)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), size, len(lines), nil
+}
+
+// webappEmbedHeader marks go files generated by EmbedWebapp
+const webappEmbedHeader = "// Code generated by qri_build. DO NOT EDIT.\n"
+
+// EmbeddedWebapp is a webapp bundle built into qri binaries
+type EmbeddedWebapp struct {
+	Version string
+	Bundle  *WebappBundle
+	// generated are the paths EmbedWebapp wrote into the qri repo
+	generated []string
+}
+
+// Path is the IPFS path qri serves the webapp from
+func (w *EmbeddedWebapp) Path() string {
+	return "/ipfs/" + w.Bundle.CID
+}
+
+// Ldflags returns linker flags setting the configured variable to the
+// webapp's IPFS path
+func (w *EmbeddedWebapp) Ldflags(c WebappConfig) string {
+	if c.PathVar == "" {
+		return ""
+	}
+	return fmt.Sprintf("-X %s=%s", c.PathVar, w.Path())
+}
+
+// EmbedWebapp prepares a built webapp bundle for qri builds. The bundle is
+// added to & pinned on the IPFS node client talks to, so the CID qri is
+// linked to can be fetched. With embed set the bundle is also copied into the
+// EmbedDir package of the qri repo along with a generated go:embed file. Call
+// Cleanup once builds are finished to remove them
+func EmbedWebapp(qriRepoPath, webappDir, variant string, embed bool, c WebappConfig, client *IPFSClient) (*EmbeddedWebapp, error) {
+	m, err := LoadWebappManifest(webappDir)
+	if err != nil {
+		return nil, err
+	}
+	b := m.Bundle(variant)
+	if b == nil {
+		return nil, fmt.Errorf("no %s webapp bundle in %s", variant, webappDir)
+	}
+	if b.CID == "" {
+		return nil, fmt.Errorf("%s webapp bundle has no CID. is an IPFS node running?", variant)
+	}
+	if client == nil {
+		return nil, fmt.Errorf("an IPFS node is required to add the %s webapp bundle", variant)
+	}
+	obj, err := client.Add(filepath.Join(webappDir, b.Dir), logProgress(variant+" webapp"))
+	if err != nil {
+		return nil, fmt.Errorf("adding %s webapp to ipfs: %s", variant, err)
+	}
+	if obj.CID != b.CID {
+		return nil, fmt.Errorf("%s webapp was added as %s, but %s records %s. rebuild the webapp", variant, obj.CID, webappManifestFilename, b.CID)
+	}
+	log.Infof("added %s webapp as %s", variant, obj.CID)
+	w := &EmbeddedWebapp{Version: m.Version, Bundle: b}
+	if !embed {
+		return w, nil
+	}
+
+	if c.EmbedDir == "" {
+		return nil, fmt.Errorf("no webapp embedDir configured")
+	}
+	pkgDir := filepath.Join(qriRepoPath, c.EmbedDir)
+	pkg, err := goPackageName(pkgDir)
+	if err != nil {
+		return nil, err
+	}
+	goFile := filepath.Join(pkgDir, "webapp_bundle.go")
+	if data, err := ioutil.ReadFile(goFile); err == nil && !strings.HasPrefix(string(data), webappEmbedHeader) {
+		return nil, fmt.Errorf("%s exists & wasn't generated by qri_build", goFile)
+	}
+	bundleDir := filepath.Join(pkgDir, "webapp_bundle")
+	if err := os.RemoveAll(bundleDir); err != nil {
+		return nil, err
+	}
+	w.generated = []string{bundleDir, goFile}
+	if err := copyDir(filepath.Join(webappDir, b.Dir), bundleDir); err != nil {
+		w.Cleanup()
+		return nil, fmt.Errorf("copying webapp bundle: %s", err)
+	}
+
+	src := fmt.Sprintf(`%s
+package %s
+
+import "embed"
+
+// WebappBundleCID is the IPFS CID of WebappBundle
+const WebappBundleCID = %q
+
+// WebappBundle is webapp %s, built by qri_build
+//go:embed webapp_bundle
+var WebappBundle embed.FS
+`, webappEmbedHeader, pkg, b.CID, m.Version)
+	if err := ioutil.WriteFile(goFile, []byte(src), 0644); err != nil {
+		w.Cleanup()
+		return nil, err
+	}
+	log.Infof("embedding webapp %s (%s) in package %s", m.Version, b.CID, c.EmbedDir)
+	return w, nil
+}
+
+// Cleanup removes files EmbedWebapp generated in the qri repo
+func (w *EmbeddedWebapp) Cleanup() error {
+	for _, path := range w.generated {
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+	w.generated = nil
+	return nil
+}
+
+// goPackageName reads the package clause of the first non-test go file in dir
+func goPackageName(dir string) (string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return "", err
+	}
+	for _, path := range paths {
+		if strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if fields := strings.Fields(line); len(fields) == 2 && fields[0] == "package" {
+				return fields[1], nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no go package in %s", dir)
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// writeTestWebapp writes a built minified webapp bundle & its manifest to
+// dir, recording cid as the bundle's CID
+func writeTestWebapp(t *testing.T, dir, cid string) {
+	t.Helper()
+	writeFiles(t, dir, map[string]string{
+		"minified/index.html":       "<html></html>\n",
+		"minified/static/js/app.js": "console.log('qri')\n",
+	})
+	m := &WebappManifest{Version: "0.5.0", Bundles: []*WebappBundle{{Variant: "minified", Dir: "minified", CID: cid}}}
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, webappManifestFilename), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEmbedWebappAddsBundle(t *testing.T) {
+	api := NewFakeIPFSAPI()
+	client := newTestIPFSClient(t, api)
+
+	dir := t.TempDir()
+	writeTestWebapp(t, dir, "")
+	cid, err := client.Hash(filepath.Join(dir, "minified"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(api.Objects) != 0 {
+		t.Fatalf("hashing stored %d objects", len(api.Objects))
+	}
+	writeTestWebapp(t, dir, cid)
+
+	w, err := EmbedWebapp("", dir, "minified", false, WebappConfig{PathVar: "qri/api.DefaultWebappPath"}, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := api.Objects[cid]; !ok {
+		t.Errorf("expected %s to be added to the node before linking", cid)
+	}
+	if expect, got := "-X qri/api.DefaultWebappPath=/ipfs/"+cid, w.Ldflags(WebappConfig{PathVar: "qri/api.DefaultWebappPath"}); got != expect {
+		t.Errorf("ldflags mismatch. expected %q, got %q", expect, got)
+	}
+}
+
+func TestEmbedWebappCIDMismatch(t *testing.T) {
+	api := NewFakeIPFSAPI()
+	client := newTestIPFSClient(t, api)
+
+	dir := t.TempDir()
+	writeTestWebapp(t, dir, fakeCID(make([]byte, 32)))
+	_, err := EmbedWebapp("", dir, "minified", false, WebappConfig{}, client)
+	if err == nil || !strings.Contains(err.Error(), "rebuild the webapp") {
+		t.Errorf("expected CID mismatch error, got %v", err)
+	}
+
+	if _, err := EmbedWebapp("", dir, "minified", false, WebappConfig{}, nil); err == nil {
+		t.Error("expected an error without an IPFS node")
+	}
+}
+
+// fakeFrontendBuild stands in for the frontend's yarn scripts, writing a
+// different bundle to the build dir for each script
+var fakeFrontendBuild = executorFunc(func(cmd *exec.Cmd) error {
+	if len(cmd.Args) != 3 || cmd.Args[0] != "yarn" || cmd.Args[1] != "run" {
+		return nil
+	}
+	files := map[string]map[string]string{
+		"build":     {"index.html": "<html>minified</html>", "static/js/app.min.js": "min"},
+		"build:dev": {"index.html": "<html>unminified</html>", "static/js/app.js": "dev"},
+	}[cmd.Args[2]]
+	for name, data := range files {
+		path := filepath.Join(cmd.Dir, "dist", filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+})
+
+func TestBuildWebapp(t *testing.T) {
+	rec := &RecordingExecutor{Fallback: fakeFrontendBuild}
+	useExecutor(t, rec)
+	api := NewFakeIPFSAPI()
+	client := newTestIPFSClient(t, api)
+
+	frontend := t.TempDir()
+	writeFiles(t, frontend, map[string]string{
+		"package.json":    `{"name": "qri-frontend", "version": "0.5.0"}`,
+		"dist/stale.html": "left by an earlier build",
+	})
+	out := filepath.Join(t.TempDir(), "webapp")
+
+	m, err := BuildWebapp(frontend, out, []string{"minified", "unminified"}, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var builds []string
+	for _, c := range rec.Commands() {
+		if strings.HasPrefix(c.Line, "yarn") {
+			if c.Dir != frontend {
+				t.Errorf("%s: expected to run in %s, got %s", c.Line, frontend, c.Dir)
+			}
+			builds = append(builds, c.Line)
+		}
+	}
+	if expect := []string{"yarn", "yarn run build", "yarn run build:dev"}; !reflect.DeepEqual(builds, expect) {
+		t.Errorf("expected yarn commands %v, got %v", expect, builds)
+	}
+
+	expectFiles := map[string][]string{
+		"minified":   {"index.html", "static/js/app.min.js"},
+		"unminified": {"index.html", "static/js/app.js"},
+	}
+	for variant, names := range expectFiles {
+		var got []string
+		filepath.Walk(filepath.Join(out, variant), func(path string, fi os.FileInfo, err error) error {
+			if err == nil && !fi.IsDir() {
+				rel, _ := filepath.Rel(filepath.Join(out, variant), path)
+				got = append(got, filepath.ToSlash(rel))
+			}
+			return err
+		})
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, names) {
+			t.Errorf("%s: expected files %v, got %v", variant, names, got)
+		}
+	}
+
+	loaded, err := LoadWebappManifest(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.Bundles, m.Bundles) || loaded.Version != "0.5.0" {
+		t.Errorf("expected %s to match the returned manifest", webappManifestFilename)
+	}
+	if len(loaded.Bundles) != 2 || loaded.Bundles[0].Variant != "minified" || loaded.Bundles[1].Variant != "unminified" {
+		t.Fatalf("expected minified & unminified bundles, got %+v", loaded.Bundles)
+	}
+	for _, b := range loaded.Bundles {
+		dir := filepath.Join(out, b.Dir)
+		sum, size, files, err := treeHash(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.SHA256 != sum || b.Size != size || b.Files != files || files != 2 {
+			t.Errorf("%s: tree hash mismatch. manifest %+v, directory %s %d bytes %d files", b.Variant, b, sum, size, files)
+		}
+		cid, err := client.Hash(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b.CID != cid {
+			t.Errorf("%s: expected cid %s, got %s", b.Variant, cid, b.CID)
+		}
+	}
+	if loaded.Bundles[0].SHA256 == loaded.Bundles[1].SHA256 {
+		t.Error("expected variants to hash differently")
+	}
+	if len(api.Objects) != 0 {
+		t.Errorf("expected building to only calculate CIDs, the node stored %d objects", len(api.Objects))
+	}
+}
+
+func TestBuildWebappVariantErrors(t *testing.T) {
+	rec := &RecordingExecutor{Fallback: fakeFrontendBuild}
+	useExecutor(t, rec)
+	frontend := t.TempDir()
+	writeFiles(t, frontend, map[string]string{"package.json": `{"version": "0.5.0"}`})
+
+	_, err := BuildWebapp(frontend, t.TempDir(), []string{"minified", "debug"}, nil)
+	if err == nil || !strings.Contains(err.Error(), `no build script configured for webapp variant "debug"`) {
+		t.Errorf("expected an unconfigured variant error, got %v", err)
+	}
+	if lines := rec.Lines(); len(lines) != 0 {
+		t.Errorf("expected nothing to run, got %v", lines)
+	}
+
+	if _, err := BuildWebapp("", t.TempDir(), []string{"minified"}, nil); err == nil {
+		t.Error("expected a missing frontend path to fail")
+	}
+	if _, err := BuildWebapp(filepath.Join(frontend, "missing"), t.TempDir(), []string{"minified"}, nil); err == nil {
+		t.Error("expected a nonexistent frontend path to fail")
+	}
+}
+
+func TestTreeHash(t *testing.T) {
+	a, b := t.TempDir(), t.TempDir()
+	files := map[string]string{
+		"index.html":       "<html></html>",
+		"static/js/app.js": "console.log('qri')",
+		"static/app.css":   "body {}",
+	}
+	writeFiles(t, a, files)
+	writeFiles(t, b, files)
+
+	sumA, sizeA, filesA, err := treeHash(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sumB, sizeB, filesB, err := treeHash(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sumA != sumB || sizeA != sizeB || filesA != filesB {
+		t.Errorf("expected identical trees to hash the same: %s %d %d vs %s %d %d", sumA, sizeA, filesA, sumB, sizeB, filesB)
+	}
+	if sizeA != 38 || filesA != 3 {
+		t.Errorf("expected 3 files & 38 bytes, got %d files & %d bytes", filesA, sizeA)
+	}
+
+	copied := filepath.Join(t.TempDir(), "copy")
+	if err := copyDir(a, copied); err != nil {
+		t.Fatal(err)
+	}
+	if sum, _, _, err := treeHash(copied); err != nil || sum != sumA {
+		t.Errorf("expected a copied tree to hash the same, got %s (%v)", sum, err)
+	}
+
+	// moving a file changes the hash, even though contents are the same
+	if err := os.Rename(filepath.Join(b, "static/app.css"), filepath.Join(b, "app.css")); err != nil {
+		t.Fatal(err)
+	}
+	if sum, _, _, err := treeHash(b); err != nil || sum == sumA {
+		t.Errorf("expected a moved file to change the hash")
+	}
+	writeFiles(t, copied, map[string]string{"index.html": "<html>changed</html>"})
+	if sum, _, _, err := treeHash(copied); err != nil || sum == sumA {
+		t.Errorf("expected changed contents to change the hash")
+	}
+}